@@ -2,39 +2,54 @@ package cmd
 
 import (
 	"fmt"
-	"net/url"
 
 	"github.com/dusnm/minidlna-scrobble/pkg/constants"
 	"github.com/dusnm/minidlna-scrobble/pkg/container"
 	"github.com/spf13/cobra"
 )
 
+const (
+	flagAccount  = "account"
+	flagAccountS = "a"
+)
+
 var authCmd = &cobra.Command{
 	Use:   "auth",
-	Short: "Authenticate with last.fm",
+	Short: "Authenticate with last.fm or another Audioscrobbler compatible service",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := cmd.Context()
 		c := ctx.Value(constants.ContextKeyContainer).(*container.Container)
 		logger := c.Logger.With().Str("command", "auth").Logger()
-		authService := c.GetAuthService()
-		sessionCacheService := c.GetSessionCacheService()
-		token, err := authService.GetToken(ctx)
+
+		account, err := cmd.Flags().GetString(flagAccount)
 		if err != nil {
 			logger.Fatal().Err(err).Msg("")
 		}
 
-		userAPI, _ := url.Parse(constants.UserAPIBaseURL)
-		userAPI.Path += "/auth/"
+		if account == constants.BackendListenBrainz {
+			logger.Fatal().Msg("listenbrainz uses a user token, authentication isn't required")
+		}
+
+		if _, ok := c.Cfg.Account(account); !ok {
+			logger.Fatal().Str("account", account).Msg("no such account in the configuration")
+		}
 
-		query := userAPI.Query()
-		query.Add("api_key", c.Cfg.Credentials.APIKey)
-		query.Add("token", token)
+		authService := c.GetAuthService(account)
+		sessionCacheService := c.GetSessionCacheService(account)
+		token, err := authService.GetToken(ctx)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
 
-		userAPI.RawQuery = query.Encode()
+		authURL, err := authService.AuthorizationURL(token)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
 
 		fmt.Printf(
-			"Authenticate with last.fm by following the provided link. Afterwards, press RETURN to continue.\n\n%s\n",
-			userAPI.String(),
+			"Authenticate with %s by following the provided link. Afterwards, press RETURN to continue.\n\n%s\n",
+			account,
+			authURL,
 		)
 
 		// Block until the user authenticates the session
@@ -54,5 +69,14 @@ var authCmd = &cobra.Command{
 }
 
 func init() {
+	authCmd.
+		Flags().
+		StringP(
+			flagAccount,
+			flagAccountS,
+			constants.BackendLastFM,
+			"name of the account to authenticate, as configured in accounts",
+		)
+
 	rootCmd.AddCommand(authCmd)
 }
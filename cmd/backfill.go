@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
+	"github.com/dusnm/minidlna-scrobble/pkg/container"
+	"github.com/dusnm/minidlna-scrobble/pkg/helpers"
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/replay"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+	"github.com/dusnm/minidlna-scrobble/pkg/rules"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagFile    = "file"
+	flagFileS   = "f"
+	flagSince   = "since"
+	flagUntil   = "until"
+	flagDryRun  = "dry-run"
+	flagDryRunS = "n"
+)
+
+var backfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Scrobble historical plays from existing minidlna log files",
+	Long: `Reads the minidlna log file and its rotated siblings (e.g. minidlna.log.1, minidlna.log.2.gz),
+and queues every play found in them with its original time, applying the same rules as the scrobble command.
+The scrobble command sends them. Plays already scrobbled or queued, according to the listening history, are left out.
+Note that last.fm ignores scrobbles older than two weeks.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		c := ctx.Value(constants.ContextKeyContainer).(*container.Container)
+		defer c.Close()
+
+		logger := c.Logger.With().Str("command", "backfill").Logger()
+
+		file, _ := cmd.Flags().GetString(flagFile)
+		if file == "" {
+			file = c.Cfg.LogFile
+		}
+
+		since, until, err := timeWindow(cmd)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		dryRun, _ := cmd.Flags().GetBool(flagDryRun)
+
+		files, err := replay.Files(file)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		serves := make([]replay.Serve, 0)
+		for _, f := range files {
+			fileServes, err := replay.ReadFile(f)
+			if err != nil {
+				logger.Fatal().Err(err).Str("file", f).Msg("")
+			}
+
+			logger.
+				Info().
+				Str("file", f).
+				Int("serves", len(fileServes)).
+				Msg("read log file")
+
+			serves = append(serves, fileServes...)
+		}
+
+		plays, skipped := replay.Plays(
+			ctx,
+			replay.Merge(serves),
+			c.GetMetadataRepository().GetByID,
+			rules.New(c.Cfg.Rules),
+			c.Clock.Now(),
+		)
+
+		for _, s := range skipped {
+			logger.
+				Debug().
+				Time("time", s.Serve.Timestamp).
+				Int("id", s.Serve.DetailID).
+				Str("path", s.Serve.Path).
+				Str("reason", s.Reason).
+				Msg("not a play")
+		}
+
+		plays = inWindow(plays, since, until)
+		if dryRun {
+			tracks := make([]models.Track, 0, len(plays))
+			for _, play := range plays {
+				tracks = append(tracks, play.Track)
+			}
+
+			printPlays(tracks)
+			return
+		}
+
+		if len(plays) == 0 {
+			fmt.Println("No plays found.")
+			return
+		}
+
+		recorded := make([]history.Play, 0, len(plays))
+		for _, play := range plays {
+			recorded = append(recorded, history.Play{
+				DetailID: play.Serve.DetailID,
+				Path:     play.Serve.Path,
+				Track:    play.Track,
+			})
+		}
+
+		// Sending is left to the scrobble command, which may be running
+		// already, so the same entries aren't submitted by both
+		enqueued, err := c.GetJobService().Enqueue(ctx, recorded...)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		fmt.Printf(
+			"%d plays queued, %d already scrobbled or queued. They're sent by the scrobble command, "+
+				"right away if it's running.\n",
+			enqueued,
+			len(plays)-enqueued,
+		)
+	},
+}
+
+// timeWindow returns the --since and --until flags, the zero time means no limit.
+func timeWindow(cmd *cobra.Command) (time.Time, time.Time, error) {
+	sinceFlag, _ := cmd.Flags().GetString(flagSince)
+	since, err := helpers.ParseTime(sinceFlag)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	untilFlag, _ := cmd.Flags().GetString(flagUntil)
+	until, err := helpers.ParseTime(untilFlag)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return since, until, nil
+}
+
+func inWindow(plays []replay.Play, since, until time.Time) []replay.Play {
+	filtered := make([]replay.Play, 0, len(plays))
+	for _, play := range plays {
+		if !since.IsZero() && play.Track.Timestamp.Before(since) {
+			continue
+		}
+
+		if !until.IsZero() && !play.Track.Timestamp.Before(until) {
+			continue
+		}
+
+		filtered = append(filtered, play)
+	}
+
+	return filtered
+}
+
+func printPlays(plays []models.Track) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tARTIST\tTRACK\tALBUM")
+	for _, play := range plays {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\n",
+			play.Timestamp.Format(time.DateTime),
+			play.Artist,
+			play.Name,
+			play.Album,
+		)
+	}
+
+	w.Flush()
+	fmt.Printf("\n%d plays found.\n", len(plays))
+}
+
+func init() {
+	backfillCmd.Flags().StringP(flagFile, flagFileS, "", "the minidlna log file, defaults to the configured one")
+	backfillCmd.Flags().String(flagSince, "", "only plays at or after this time, e.g. 2025-01-31 or 2025-01-31 18:00")
+	backfillCmd.Flags().String(flagUntil, "", "only plays before this time, e.g. 2025-02-28")
+	backfillCmd.Flags().BoolP(flagDryRun, flagDryRunS, false, "only list the plays that would be scrobbled")
+
+	rootCmd.AddCommand(backfillCmd)
+}
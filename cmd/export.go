@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
+	"github.com/dusnm/minidlna-scrobble/pkg/container"
+	"github.com/dusnm/minidlna-scrobble/pkg/formats"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagFormat = "format"
+
+	formatCSV          = "csv"
+	formatJSONLines    = "jsonl"
+	formatScrobblerLog = "scrobbler-log"
+)
+
+var ErrUnknownFormat = errors.New("unknown file format, use one of: csv, jsonl, scrobbler-log")
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the recorded plays to CSV, JSON Lines or a Rockbox .scrobbler.log",
+	Long: `Writes the plays recorded by the scrobble command, oldest first. Plays that weren't scrobbled,
+because they were cut short, too short or ignored by a rule, are marked as skipped, which is the S rating
+of the .scrobbler.log format.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		c := ctx.Value(constants.ContextKeyContainer).(*container.Container)
+		defer c.Close()
+
+		logger := c.Logger.With().Str("command", "export").Logger()
+
+		since, until, err := timeWindow(cmd)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		format, _ := cmd.Flags().GetString(flagFormat)
+		if format != formatCSV && format != formatJSONLines && format != formatScrobblerLog {
+			logger.Fatal().Err(ErrUnknownFormat).Msg("")
+		}
+
+		plays, err := c.GetHistoryRepository().Find(ctx, history.Filter{
+			Since: since,
+			Until: until,
+		})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		var out io.Writer = os.Stdout
+		file, _ := cmd.Flags().GetString(flagFile)
+		if file != "" {
+			f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+			if err != nil {
+				logger.Fatal().Err(err).Msg("")
+			}
+
+			defer f.Close()
+			out = f
+		}
+
+		exported := make([]formats.Play, 0, len(plays))
+		for _, play := range plays {
+			exported = append(exported, formats.Play{
+				Track:   play.Track,
+				Skipped: play.Skipped != "",
+			})
+		}
+
+		switch format {
+		case formatCSV:
+			err = formats.WriteCSV(out, exported)
+		case formatJSONLines:
+			err = formats.WriteJSONLines(out, exported)
+		case formatScrobblerLog:
+			err = formats.WriteScrobblerLog(out, exported, strings.TrimSpace("minidlna-scrobble "+version))
+		}
+
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringP(flagFile, flagFileS, "", "the file to write to, defaults to the standard output")
+	exportCmd.Flags().String(flagFormat, formatCSV, "the file format, can be one of: csv, jsonl, scrobbler-log")
+	exportCmd.Flags().String(flagSince, "", "only plays at or after this time, e.g. 2025-01-31 or 2025-01-31 18:00")
+	exportCmd.Flags().String(flagUntil, "", "only plays before this time, e.g. 2025-02-28")
+
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
+	"github.com/dusnm/minidlna-scrobble/pkg/container"
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagArtist  = "artist"
+	flagStatus  = "status"
+	flagStatusS = "s"
+	flagLimit   = "limit"
+	flagOutput  = "output"
+	flagOutputS = "o"
+
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+var ErrUnknownOutput = errors.New("unknown output format, use either table or json")
+
+type (
+	// historyEntry is how a play is written as JSON.
+	historyEntry struct {
+		PlayedAt time.Time        `json:"played_at"`
+		DetailID int              `json:"detail_id"`
+		Path     string           `json:"path"`
+		Artist   string           `json:"artist"`
+		Track    string           `json:"track"`
+		Album    string           `json:"album"`
+		Duration float64          `json:"duration"`
+		Skipped  string           `json:"skipped,omitempty"`
+		Outcomes []historyOutcome `json:"outcomes"`
+	}
+
+	historyOutcome struct {
+		Target    string     `json:"target"`
+		Status    string     `json:"status"`
+		Reason    string     `json:"reason,omitempty"`
+		Corrected *corrected `json:"corrected,omitempty"`
+		UpdatedAt time.Time  `json:"updated_at"`
+	}
+
+	corrected struct {
+		Artist string `json:"artist"`
+		Track  string `json:"track"`
+		Album  string `json:"album"`
+	}
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the plays detected in the minidlna log, and what became of them",
+	Long: `Lists the plays recorded by the scrobble command, latest last, with their outcome at every
+scrobbling service and the corrections the service made, if any.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		c := ctx.Value(constants.ContextKeyContainer).(*container.Container)
+		defer c.Close()
+
+		logger := c.Logger.With().Str("command", "history").Logger()
+
+		since, until, err := timeWindow(cmd)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		artist, _ := cmd.Flags().GetString(flagArtist)
+		status, _ := cmd.Flags().GetString(flagStatus)
+		limit, _ := cmd.Flags().GetInt(flagLimit)
+		output, _ := cmd.Flags().GetString(flagOutput)
+		if output != outputTable && output != outputJSON {
+			logger.Fatal().Err(ErrUnknownOutput).Msg("")
+		}
+
+		plays, err := c.GetHistoryRepository().Find(ctx, history.Filter{
+			Since:  since,
+			Until:  until,
+			Artist: artist,
+			Status: status,
+			Limit:  limit,
+		})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		if output == outputJSON {
+			err = printHistoryJSON(os.Stdout, plays)
+		} else {
+			err = printHistory(os.Stdout, plays)
+		}
+
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+	},
+}
+
+func printHistory(out io.Writer, plays []history.Play) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tARTIST\tTRACK\tALBUM\tSTATUS")
+	for _, play := range plays {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\n",
+			play.Track.Timestamp.Format(time.DateTime),
+			play.Track.Artist,
+			play.Track.Name,
+			play.Track.Album,
+			playStatus(play),
+		)
+	}
+
+	return w.Flush()
+}
+
+// playStatus sums up the outcomes of the play in a single column.
+func playStatus(play history.Play) string {
+	if play.Skipped != "" {
+		return "skipped: " + play.Skipped
+	}
+
+	statuses := make([]string, 0, len(play.Outcomes))
+	for _, outcome := range play.Outcomes {
+		status := outcome.Target + ": " + outcome.Status
+		if outcome.Reason != "" {
+			status += " (" + outcome.Reason + ")"
+		}
+
+		if outcome.Corrected != (models.Track{}) {
+			status += fmt.Sprintf(
+				" as %s - %s",
+				outcome.Corrected.Artist,
+				outcome.Corrected.Name,
+			)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return strings.Join(statuses, ", ")
+}
+
+func printHistoryJSON(out io.Writer, plays []history.Play) error {
+	entries := make([]historyEntry, 0, len(plays))
+	for _, play := range plays {
+		entry := historyEntry{
+			PlayedAt: play.Track.Timestamp,
+			DetailID: play.DetailID,
+			Path:     play.Path,
+			Artist:   play.Track.Artist,
+			Track:    play.Track.Name,
+			Album:    play.Track.Album,
+			Duration: play.Track.Duration.Seconds(),
+			Skipped:  play.Skipped,
+			Outcomes: make([]historyOutcome, 0, len(play.Outcomes)),
+		}
+
+		for _, outcome := range play.Outcomes {
+			o := historyOutcome{
+				Target:    outcome.Target,
+				Status:    outcome.Status,
+				Reason:    outcome.Reason,
+				UpdatedAt: outcome.UpdatedAt,
+			}
+
+			if outcome.Corrected != (models.Track{}) {
+				o.Corrected = &corrected{
+					Artist: outcome.Corrected.Artist,
+					Track:  outcome.Corrected.Name,
+					Album:  outcome.Corrected.Album,
+				}
+			}
+
+			entry.Outcomes = append(entry.Outcomes, o)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(entries)
+}
+
+func init() {
+	historyCmd.Flags().String(flagSince, "", "only plays at or after this time, e.g. 2025-01-31 or 2025-01-31 18:00")
+	historyCmd.Flags().String(flagUntil, "", "only plays before this time, e.g. 2025-02-28")
+	historyCmd.Flags().String(flagArtist, "", "only plays of artists whose name contains this")
+	historyCmd.Flags().StringP(
+		flagStatus,
+		flagStatusS,
+		"",
+		"only plays with this status at any service, can be one of: pending, sent, failed, ignored, skipped",
+	)
+	historyCmd.Flags().Int(flagLimit, 50, "only the latest plays, 0 lists all of them")
+	historyCmd.Flags().StringP(flagOutput, flagOutputS, outputTable, "the output format, either table or json")
+
+	rootCmd.AddCommand(historyCmd)
+}
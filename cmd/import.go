@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
+	"github.com/dusnm/minidlna-scrobble/pkg/container"
+	"github.com/dusnm/minidlna-scrobble/pkg/formats"
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/queue"
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Scrobble the plays of a Rockbox .scrobbler.log or a CSV file",
+	Long: `Reads the plays of a .scrobbler.log written by Rockbox or another portable player, or of a CSV file
+like the ones written by the export command, and queues them with their original time for the account.
+The scrobble command sends them. Skipped plays, and plays that were already scrobbled to the account, are
+left out. Imported plays are recorded in the listening history, so importing the same file again doesn't
+scrobble them twice.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		c := ctx.Value(constants.ContextKeyContainer).(*container.Container)
+		defer c.Close()
+
+		logger := c.Logger.With().Str("command", "import").Logger()
+
+		account, _ := cmd.Flags().GetString(flagAccount)
+		if account == constants.BackendListenBrainz {
+			logger.Fatal().Msg("only last.fm and other Audioscrobbler compatible accounts are supported")
+		}
+
+		if _, ok := c.Cfg.Account(account); !ok {
+			logger.Fatal().Str("account", account).Msg("no such account in the configuration")
+		}
+
+		// The plays are sent by the scrobble command, which only sends to the backends
+		if !c.Cfg.HasBackend(account) {
+			logger.Fatal().Str("account", account).Msg("the account isn't one of the configured backends")
+		}
+
+		file, err := filepath.Abs(args[0])
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		format, _ := cmd.Flags().GetString(flagFormat)
+		plays, err := readPlays(file, format)
+		if err != nil {
+			logger.Fatal().Err(err).Str("file", file).Msg("")
+		}
+
+		listened := make([]formats.Play, 0, len(plays))
+		for _, play := range plays {
+			if !play.Skipped {
+				listened = append(listened, play)
+			}
+		}
+
+		skipped := len(plays) - len(listened)
+
+		scrobbled, err := scrobbledPlays(ctx, c.GetHistoryRepository(), c.GetQueueRepository(), account, listened)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		listened, duplicates := formats.Dedupe(listened, scrobbled)
+
+		tracks := make([]models.Track, 0, len(listened))
+		for _, play := range listened {
+			tracks = append(tracks, play.Track)
+		}
+
+		dryRun, _ := cmd.Flags().GetBool(flagDryRun)
+		if dryRun {
+			printPlays(tracks)
+			fmt.Printf("%d skipped, %d duplicates or already scrobbled.\n", skipped, duplicates)
+			return
+		}
+
+		recorded := make([]history.Play, 0, len(tracks))
+		for _, track := range tracks {
+			recorded = append(recorded, history.Play{Path: file, Track: track})
+		}
+
+		// Sending is left to the scrobble command, which may be running
+		// already, so the same entries aren't submitted by both
+		enqueued, err := c.GetJobService().EnqueueTo(ctx, []string{account}, recorded...)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		fmt.Printf(
+			"%d queued, %d skipped, %d duplicates or already scrobbled. They're sent by the scrobble command, "+
+				"right away if it's running.\n",
+			enqueued,
+			skipped,
+			duplicates+len(tracks)-enqueued,
+		)
+	},
+}
+
+// readPlays reads the file in the format, which is told by the extension if it's empty.
+func readPlays(file string, format string) ([]formats.Play, error) {
+	if format == "" {
+		format = formatScrobblerLog
+		if strings.EqualFold(filepath.Ext(file), ".csv") {
+			format = formatCSV
+		}
+	}
+
+	f, err := os.OpenFile(file, os.O_RDONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	switch format {
+	case formatCSV:
+		return formats.ReadCSV(f)
+	case formatScrobblerLog:
+		return formats.ReadScrobblerLog(f)
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// scrobbledPlays returns the plays in the time span of the plays that were scrobbled to the
+// account, or are queued to be. The queue also has the plays from before there was a history.
+func scrobbledPlays(
+	ctx context.Context,
+	historyRepo *history.Repository,
+	queueRepo *queue.Repository,
+	account string,
+	plays []formats.Play,
+) ([]models.Track, error) {
+	if len(plays) == 0 {
+		return []models.Track{}, nil
+	}
+
+	first := slices.MinFunc(plays, func(a, b formats.Play) int {
+		return a.Track.Timestamp.Compare(b.Track.Timestamp)
+	})
+
+	last := slices.MaxFunc(plays, func(a, b formats.Play) int {
+		return a.Track.Timestamp.Compare(b.Track.Timestamp)
+	})
+
+	since := first.Track.Timestamp
+	until := last.Track.Timestamp.Add(time.Second)
+	recorded, err := historyRepo.Find(ctx, history.Filter{Since: since, Until: until})
+	if err != nil {
+		return nil, err
+	}
+
+	queued, err := queueRepo.Played(ctx, account, since, until)
+	if err != nil {
+		return nil, err
+	}
+
+	scrobbled := make([]models.Track, 0, len(recorded)+len(queued))
+	for _, entry := range queued {
+		scrobbled = append(scrobbled, entry.Track)
+	}
+
+	for _, play := range recorded {
+		if slices.ContainsFunc(play.Outcomes, func(o history.Outcome) bool {
+			return o.Target == account && (o.Status == history.StatusSent || o.Status == history.StatusPending)
+		}) {
+			scrobbled = append(scrobbled, play.Track)
+		}
+	}
+
+	return scrobbled, nil
+}
+
+func init() {
+	importCmd.Flags().StringP(
+		flagAccount,
+		flagAccountS,
+		constants.BackendLastFM,
+		"name of the account to scrobble to, as configured in accounts",
+	)
+	importCmd.Flags().String(flagFormat, "", "the file format, either csv or scrobbler-log, defaults to the one of the extension")
+	importCmd.Flags().BoolP(flagDryRun, flagDryRunS, false, "only list the plays that would be scrobbled")
+
+	rootCmd.AddCommand(importCmd)
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
+	"github.com/dusnm/minidlna-scrobble/pkg/container"
+	"github.com/dusnm/minidlna-scrobble/pkg/reconcile"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagTolerance = "tolerance"
+	flagResubmit  = "resubmit"
+)
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Find recorded plays that never made it to last.fm, and resubmit them",
+	Long: `Compares the plays recorded by the scrobble command with the recent tracks of the authenticated
+user, and lists the plays that aren't among them. A play matches a recent track of the same artist and
+track that was scrobbled within the tolerance of its time. The window defaults to the last two weeks,
+last.fm ignores older scrobbles. Resubmitted plays are queued, and sent by the scrobble command.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		c := ctx.Value(constants.ContextKeyContainer).(*container.Container)
+		defer c.Close()
+
+		logger := c.Logger.With().Str("command", "reconcile").Logger()
+
+		account, _ := cmd.Flags().GetString(flagAccount)
+		if account == constants.BackendListenBrainz {
+			logger.Fatal().Msg("only last.fm and other Audioscrobbler compatible accounts are supported")
+		}
+
+		if _, ok := c.Cfg.Account(account); !ok {
+			logger.Fatal().Str("account", account).Msg("no such account in the configuration")
+		}
+
+		since, until, err := timeWindow(cmd)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		now := c.Clock.Now()
+		if since.IsZero() {
+			since = now.AddDate(0, 0, -14)
+		}
+
+		if until.IsZero() {
+			until = now
+		}
+
+		tolerance, _ := cmd.Flags().GetDuration(flagTolerance)
+		resubmit, _ := cmd.Flags().GetBool(flagResubmit)
+
+		// The plays are sent by the scrobble command, which only sends to the backends
+		if resubmit && !c.Cfg.HasBackend(account) {
+			logger.Fatal().Str("account", account).Msg("the account isn't one of the configured backends")
+		}
+
+		plays, err := c.GetHistoryRepository().Find(ctx, history.Filter{Since: since, Until: until})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		// Scrobbles of plays at the edges of the window can be just outside of it
+		scrobbled, err := c.GetScrobbleService(account).Scrobbled(ctx, since.Add(-tolerance), until.Add(tolerance))
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		missing := reconcile.Missing(plays, scrobbled, account, tolerance)
+		if err = printHistory(os.Stdout, missing); err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		fmt.Printf("\n%d of %d plays missing from %s.\n", len(missing), len(plays), account)
+		if !resubmit || len(missing) == 0 {
+			return
+		}
+
+		// Sending is left to the scrobble command, which may be running
+		// already, so the same entries aren't submitted by both
+		if err = c.GetJobService().Requeue(ctx, account, missing...); err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		fmt.Printf("%d plays queued. They're sent by the scrobble command, right away if it's running.\n", len(missing))
+	},
+}
+
+func init() {
+	reconcileCmd.Flags().StringP(
+		flagAccount,
+		flagAccountS,
+		constants.BackendLastFM,
+		"name of the account to reconcile with, as configured in accounts",
+	)
+	reconcileCmd.Flags().String(flagSince, "", "only plays at or after this time, defaults to two weeks ago")
+	reconcileCmd.Flags().String(flagUntil, "", "only plays before this time, defaults to now")
+	reconcileCmd.Flags().Duration(flagTolerance, time.Minute, "how far apart the times of a play and its scrobble can be")
+	reconcileCmd.Flags().Bool(flagResubmit, false, "queue the missing plays to be scrobbled again")
+
+	rootCmd.AddCommand(reconcileCmd)
+}
@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
+	"github.com/dusnm/minidlna-scrobble/pkg/container"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+	"github.com/dusnm/minidlna-scrobble/pkg/stats"
+	"github.com/spf13/cobra"
+)
+
+const flagTop = "top"
+
+type (
+	// statsOutput is how the report is written as JSON, times are in seconds.
+	statsOutput struct {
+		Plays         int          `json:"plays"`
+		ListeningTime int64        `json:"listening_time"`
+		Artists       []statsCount `json:"artists"`
+		Albums        []statsCount `json:"albums"`
+		Tracks        []statsCount `json:"tracks"`
+		Days          []statsDay   `json:"days"`
+		Hours         [7][24]int   `json:"hours"`
+		LongestStreak statsStreak  `json:"longest_streak"`
+		CurrentStreak statsStreak  `json:"current_streak"`
+	}
+
+	statsCount struct {
+		Artist string `json:"artist"`
+		Album  string `json:"album,omitempty"`
+		Track  string `json:"track,omitempty"`
+		Plays  int    `json:"plays"`
+		Time   int64  `json:"time"`
+	}
+
+	statsDay struct {
+		Date  string `json:"date"`
+		Plays int    `json:"plays"`
+	}
+
+	statsStreak struct {
+		Start string `json:"start,omitempty"`
+		End   string `json:"end,omitempty"`
+		Days  int    `json:"days"`
+	}
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show listening statistics from the recorded plays",
+	Long: `Computes the top artists, albums and tracks, the listening time, when you listen and your
+listening streaks from the plays recorded by the scrobble command. Tracks are described by the
+current minidlna metadata, plays that weren't scrobbled for being too short or skipped are left out.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		c := ctx.Value(constants.ContextKeyContainer).(*container.Container)
+		defer c.Close()
+
+		logger := c.Logger.With().Str("command", "stats").Logger()
+
+		since, until, err := timeWindow(cmd)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		top, _ := cmd.Flags().GetInt(flagTop)
+		output, _ := cmd.Flags().GetString(flagOutput)
+		if output != outputTable && output != outputJSON {
+			logger.Fatal().Err(ErrUnknownOutput).Msg("")
+		}
+
+		plays, err := c.GetHistoryRepository().Find(ctx, history.Filter{
+			Since: since,
+			Until: until,
+		})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		report, err := stats.Compute(ctx, plays, c.GetMetadataRepository().GetByID, top, c.Clock.Now())
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+
+		if output == outputJSON {
+			err = printStatsJSON(os.Stdout, report)
+		} else {
+			err = printStats(os.Stdout, report)
+		}
+
+		if err != nil {
+			logger.Fatal().Err(err).Msg("")
+		}
+	},
+}
+
+func printStats(out io.Writer, report stats.Report) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Plays:\t%d\n", report.Plays)
+	fmt.Fprintf(w, "Listening time:\t%s\n", report.ListeningTime.Round(time.Second))
+	fmt.Fprintf(w, "Longest streak:\t%s\n", formatStreak(report.LongestStreak))
+	fmt.Fprintf(w, "Current streak:\t%s\n", formatStreak(report.CurrentStreak))
+
+	fmt.Fprintln(w, "\nARTIST\tPLAYS\tTIME")
+	for _, count := range report.Artists {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", count.Artist, count.Plays, count.Time.Round(time.Second))
+	}
+
+	fmt.Fprintln(w, "\nARTIST\tALBUM\tPLAYS\tTIME")
+	for _, count := range report.Albums {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", count.Artist, count.Album, count.Plays, count.Time.Round(time.Second))
+	}
+
+	fmt.Fprintln(w, "\nARTIST\tTRACK\tPLAYS\tTIME")
+	for _, count := range report.Tracks {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", count.Artist, count.Name, count.Plays, count.Time.Round(time.Second))
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := printHourHeatmap(out, report.Hours); err != nil {
+		return err
+	}
+
+	return printDayHeatmap(out, report.Days)
+}
+
+// printHourHeatmap writes the plays by weekday and hour of the day.
+func printHourHeatmap(out io.Writer, hours [7][24]int) error {
+	w := tabwriter.NewWriter(out, 0, 4, 1, ' ', tabwriter.AlignRight)
+	header := make([]string, 0, 24)
+	for hour := range 24 {
+		header = append(header, fmt.Sprintf("%02d", hour))
+	}
+
+	fmt.Fprintf(w, "\n\t%s\t\n", strings.Join(header, "\t"))
+	for weekday, counts := range hours {
+		fmt.Fprintf(w, "%s\t%s\t\n", time.Weekday(weekday).String()[:3], joinCounts(counts[:]))
+	}
+
+	return w.Flush()
+}
+
+// printDayHeatmap writes the plays of every day, a week per row.
+func printDayHeatmap(out io.Writer, days []stats.Day) error {
+	if len(days) == 0 {
+		return nil
+	}
+
+	plays := make(map[string]int, len(days))
+	for _, day := range days {
+		plays[day.Date] = day.Plays
+	}
+
+	first, err := time.Parse(time.DateOnly, days[0].Date)
+	if err != nil {
+		return err
+	}
+
+	last, err := time.Parse(time.DateOnly, days[len(days)-1].Date)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "\nWEEK OF\tSun\tMon\tTue\tWed\tThu\tFri\tSat\t")
+
+	week := first.AddDate(0, 0, -int(first.Weekday()))
+	for ; !week.After(last); week = week.AddDate(0, 0, 7) {
+		counts := make([]int, 0, 7)
+		for day := range 7 {
+			counts = append(counts, plays[week.AddDate(0, 0, day).Format(time.DateOnly)])
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t\n", week.Format(time.DateOnly), joinCounts(counts))
+	}
+
+	return w.Flush()
+}
+
+// joinCounts joins the counts with tabs, leaving out zeros so the heatmap is easier to read.
+func joinCounts(counts []int) string {
+	cells := make([]string, 0, len(counts))
+	for _, count := range counts {
+		cell := "."
+		if count > 0 {
+			cell = strconv.Itoa(count)
+		}
+
+		cells = append(cells, cell)
+	}
+
+	return strings.Join(cells, "\t")
+}
+
+func formatStreak(streak stats.Streak) string {
+	if streak.Days == 0 {
+		return "none"
+	}
+
+	if streak.Days == 1 {
+		return "1 day, " + streak.Start
+	}
+
+	return fmt.Sprintf("%d days, %s to %s", streak.Days, streak.Start, streak.End)
+}
+
+func printStatsJSON(out io.Writer, report stats.Report) error {
+	output := statsOutput{
+		Plays:         report.Plays,
+		ListeningTime: int64(report.ListeningTime.Seconds()),
+		Artists:       statsCounts(report.Artists),
+		Albums:        statsCounts(report.Albums),
+		Tracks:        statsCounts(report.Tracks),
+		Days:          make([]statsDay, 0, len(report.Days)),
+		Hours:         report.Hours,
+		LongestStreak: statsStreak(report.LongestStreak),
+		CurrentStreak: statsStreak(report.CurrentStreak),
+	}
+
+	for _, day := range report.Days {
+		output.Days = append(output.Days, statsDay(day))
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(output)
+}
+
+func statsCounts(counts []stats.Count) []statsCount {
+	converted := make([]statsCount, 0, len(counts))
+	for _, count := range counts {
+		converted = append(converted, statsCount{
+			Artist: count.Artist,
+			Album:  count.Album,
+			Track:  count.Name,
+			Plays:  count.Plays,
+			Time:   int64(count.Time.Seconds()),
+		})
+	}
+
+	return converted
+}
+
+func init() {
+	statsCmd.Flags().String(flagSince, "", "only plays at or after this time, e.g. 2025-01-31 or 2025-01-31 18:00")
+	statsCmd.Flags().String(flagUntil, "", "only plays before this time, e.g. 2025-02-28")
+	statsCmd.Flags().Int(flagTop, 10, "how many of the top artists, albums and tracks to list, 0 lists all of them")
+	statsCmd.Flags().StringP(flagOutput, flagOutputS, outputTable, "the output format, either table or json")
+
+	rootCmd.AddCommand(statsCmd)
+}
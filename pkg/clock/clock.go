@@ -0,0 +1,24 @@
+package clock
+
+import "time"
+
+type (
+	// Clock is the source of time of the services that schedule work,
+	// so the scheduling can be controlled when testing them.
+	Clock interface {
+		Now() time.Time
+		// After waits for the duration to elapse and then sends the current time on the returned channel.
+		After(d time.Duration) <-chan time.Time
+	}
+
+	// Real is the system clock.
+	Real struct{}
+)
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+func (Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,78 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// Fake is a clock for tests that only moves when it's advanced.
+	Fake struct {
+		mu      sync.Mutex
+		now     time.Time
+		waiters []waiter
+	}
+
+	waiter struct {
+		at time.Time
+		ch chan time.Time
+	}
+)
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+
+	f.waiters = append(f.waiters, waiter{at: f.now.Add(d), ch: ch})
+
+	return ch
+}
+
+// Advance moves the clock forward, firing everything that was waiting for it.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	f.waiters = slices.DeleteFunc(f.waiters, func(w waiter) bool {
+		if w.at.After(f.now) {
+			return false
+		}
+
+		w.ch <- f.now
+
+		return true
+	})
+}
+
+// Pending returns the times something is waiting for, so a test can
+// make sure a timer was started before advancing the clock past it.
+func (f *Fake) Pending() []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pending := make([]time.Time, 0, len(f.waiters))
+	for _, w := range f.waiters {
+		pending = append(pending, w.at)
+	}
+
+	return pending
+}
@@ -0,0 +1,61 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 20, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+
+	immediate := f.After(0)
+	select {
+	case now := <-immediate:
+		if !now.Equal(start) {
+			t.Fatalf("expected %v, got %v", start, now)
+		}
+	default:
+		t.Fatal("expected a non-positive duration to fire immediately")
+	}
+
+	short := f.After(time.Second * 10)
+	long := f.After(time.Minute)
+	if pending := f.Pending(); len(pending) != 2 {
+		t.Fatalf("expected 2 pending timers, got %v", pending)
+	}
+
+	f.Advance(time.Second * 9)
+	select {
+	case <-short:
+		t.Fatal("fired early")
+	default:
+	}
+
+	f.Advance(time.Second * 1)
+	select {
+	case now := <-short:
+		if expected := start.Add(time.Second * 10); !now.Equal(expected) {
+			t.Fatalf("expected %v, got %v", expected, now)
+		}
+	default:
+		t.Fatal("expected the timer to fire")
+	}
+
+	select {
+	case <-long:
+		t.Fatal("fired early")
+	default:
+	}
+
+	f.Advance(time.Hour)
+	<-long
+
+	if pending := f.Pending(); len(pending) != 0 {
+		t.Fatalf("expected no pending timers, got %v", pending)
+	}
+
+	if expected := start.Add(time.Second*10 + time.Hour); !f.Now().Equal(expected) {
+		t.Fatalf("expected %v, got %v", expected, f.Now())
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/dusnm/minidlna-scrobble/pkg/constants"
 )
@@ -16,6 +20,9 @@ var (
 	ErrLogFilePathNotAbsolute = errors.New("the path to the minidlna log file must be absolute")
 	ErrAPIKeyMissing          = errors.New("you must supply the api key")
 	ErrSharedSecretMissing    = errors.New("you must supply the shared secret")
+	ErrTokenMissing           = errors.New("you must supply the listenbrainz user token")
+	ErrNoBackends             = errors.New("you must configure at least one backend")
+	ErrAccountNameMissing     = errors.New("every additional account must have a name")
 )
 
 type (
@@ -23,15 +30,87 @@ type (
 		Path string
 	}
 
+	ErrUnknownBackend struct {
+		Backend string
+	}
+
+	ErrDuplicateBackend struct {
+		Backend string
+	}
+
+	ErrInvalidURL struct {
+		Backend string
+		URL     string
+	}
+
+	ErrInvalidRule struct {
+		Rule   string
+		Reason string
+	}
+
+	// ErrInvalidAccountName is returned for account names that can't be
+	// part of a file name, the session of an account is cached by its name.
+	ErrInvalidAccountName struct {
+		Name string
+	}
+
+	// Duration is written as a string like "30s" or "4m" in the configuration file.
+	Duration time.Duration
+
+	// Rules decide when a play counts as a listen.
+	Rules struct {
+		// Tracks this long or shorter are never scrobbled
+		MinDuration Duration `json:"min_duration"`
+		// Percentage of the track that has to be played
+		Percentage float64 `json:"percentage"`
+		// Playing this long is always enough, regardless of
+		// the track duration. Zero means there's no cap.
+		MaxDelay Duration `json:"max_delay"`
+		// Tracks without a known duration are scrobbled after
+		// playing this long. Zero means they're never scrobbled.
+		UnknownDurationAfter Duration `json:"unknown_duration_after"`
+		// Either scrobble once a timer fires, or when the next track
+		// starts, judging by how long the previous one actually played
+		Mode string `json:"mode"`
+		// In boundary mode, how long to wait for the next track before
+		// the play is judged as if it lasted this long
+		IdleTimeout Duration `json:"idle_timeout"`
+		// Other tracks served this soon after a track started are taken
+		// to be prefetched by a gapless renderer, not a track change.
+		// Zero turns prefetch detection off.
+		PrefetchWindow Duration `json:"prefetch_window"`
+	}
+
+	// Credentials of an account on last.fm or any
+	// other Audioscrobbler 2.0 compatible service.
 	Credentials struct {
+		// Name identifies the account among the backends,
+		// it's always "lastfm" for the main credentials
+		Name         string `json:"name"`
 		APIKey       string `json:"api_key"`
 		SharedSecret string `json:"shared_secret"`
+		// APIURL is the base URL of the web service API
+		APIURL string `json:"api_url"`
+		// AuthURL is the base URL of the page where users authorize applications
+		AuthURL string `json:"auth_url"`
+	}
+
+	ListenBrainz struct {
+		Token  string `json:"token"`
+		APIURL string `json:"api_url"`
 	}
 
 	Config struct {
-		DBFile      string      `json:"db_file"`
-		LogFile     string      `json:"log_file"`
+		DBFile  string `json:"db_file"`
+		LogFile string `json:"log_file"`
+		// Deprecated: use Backends, kept for existing configuration files
+		Backend     string      `json:"backend"`
+		Backends    []string    `json:"backends"`
 		Credentials Credentials `json:"credentials"`
+		// Accounts on additional Audioscrobbler 2.0 compatible services
+		Accounts     []Credentials `json:"accounts"`
+		ListenBrainz ListenBrainz  `json:"listenbrainz"`
+		Rules        Rules         `json:"rules"`
 	}
 )
 
@@ -39,6 +118,57 @@ func (e ErrConfigFileNotFound) Error() string {
 	return fmt.Sprintf("config file not found at: %s", e.Path)
 }
 
+func (e ErrUnknownBackend) Error() string {
+	return fmt.Sprintf("unknown backend: %s", e.Backend)
+}
+
+func (e ErrDuplicateBackend) Error() string {
+	return fmt.Sprintf("backend configured more than once: %s", e.Backend)
+}
+
+func (e ErrInvalidURL) Error() string {
+	return fmt.Sprintf("invalid url for %s, it must be an absolute http(s) url: %s", e.Backend, e.URL)
+}
+
+func (e ErrInvalidRule) Error() string {
+	return fmt.Sprintf("invalid rule %s: %s", e.Rule, e.Reason)
+}
+
+func (e ErrInvalidAccountName) Error() string {
+	return fmt.Sprintf("invalid account name %q, it can't contain path separators or ..", e.Name)
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DefaultRules are the rules last.fm recommends.
+func DefaultRules() Rules {
+	return Rules{
+		MinDuration: Duration(time.Second * 30),
+		Percentage:  50,
+		MaxDelay:    Duration(time.Minute * 4),
+		Mode:        constants.ModeTimer,
+		IdleTimeout: Duration(time.Minute * 15),
+	}
+}
+
 func New() (*Config, error) {
 	configDir := "/etc"
 	v, set := os.LookupEnv(constants.XDGConfigDir)
@@ -71,7 +201,14 @@ func New() (*Config, error) {
 }
 
 func unmarshall(data io.Reader) (Config, error) {
-	var cfg Config
+	cfg := Config{
+		ListenBrainz: ListenBrainz{
+			APIURL: constants.ListenBrainzAPIURL,
+		},
+		// Rules left out of the file keep their defaults
+		Rules: DefaultRules(),
+	}
+
 	decoder := json.NewDecoder(data)
 	for {
 		if err := decoder.Decode(&cfg); err != nil {
@@ -83,9 +220,54 @@ func unmarshall(data io.Reader) (Config, error) {
 		}
 	}
 
+	if len(cfg.Backends) == 0 {
+		cfg.Backends = []string{constants.BackendLastFM}
+		if cfg.Backend != "" {
+			cfg.Backends = []string{cfg.Backend}
+		}
+	}
+
+	cfg.Credentials.Name = constants.BackendLastFM
+	cfg.Credentials = withDefaultURLs(cfg.Credentials)
+	for i := range cfg.Accounts {
+		cfg.Accounts[i] = withDefaultURLs(cfg.Accounts[i])
+	}
+
 	return cfg, nil
 }
 
+func withDefaultURLs(creds Credentials) Credentials {
+	if creds.APIURL == "" {
+		creds.APIURL = constants.APIBaseURL
+	}
+
+	if creds.AuthURL == "" {
+		creds.AuthURL = constants.UserAPIBaseURL
+	}
+
+	return creds
+}
+
+// HasBackend reports whether the named backend is enabled.
+func (c *Config) HasBackend(name string) bool {
+	return slices.Contains(c.Backends, name)
+}
+
+// Account returns the Audioscrobbler credentials with the given name.
+func (c *Config) Account(name string) (Credentials, bool) {
+	if name == constants.BackendLastFM {
+		return c.Credentials, true
+	}
+
+	for _, account := range c.Accounts {
+		if account.Name == name {
+			return account, true
+		}
+	}
+
+	return Credentials{}, false
+}
+
 func validate(cfg Config) error {
 	if !filepath.IsAbs(cfg.DBFile) {
 		return ErrDBFilePathNotAbsolute
@@ -95,13 +277,122 @@ func validate(cfg Config) error {
 		return ErrLogFilePathNotAbsolute
 	}
 
-	if cfg.Credentials.APIKey == "" {
+	if len(cfg.Backends) == 0 {
+		return ErrNoBackends
+	}
+
+	if err := validateRules(cfg.Rules); err != nil {
+		return err
+	}
+
+	names := map[string]struct{}{
+		constants.BackendLastFM:       {},
+		constants.BackendListenBrainz: {},
+	}
+
+	for _, account := range cfg.Accounts {
+		if account.Name == "" {
+			return ErrAccountNameMissing
+		}
+
+		if strings.ContainsAny(account.Name, `/\`) || strings.Contains(account.Name, "..") {
+			return ErrInvalidAccountName{Name: account.Name}
+		}
+
+		if _, ok := names[account.Name]; ok {
+			return ErrDuplicateBackend{Backend: account.Name}
+		}
+
+		names[account.Name] = struct{}{}
+	}
+
+	seen := make(map[string]struct{}, len(cfg.Backends))
+	for _, backend := range cfg.Backends {
+		if _, ok := seen[backend]; ok {
+			return ErrDuplicateBackend{Backend: backend}
+		}
+
+		seen[backend] = struct{}{}
+
+		if backend == constants.BackendListenBrainz {
+			if cfg.ListenBrainz.Token == "" {
+				return ErrTokenMissing
+			}
+
+			if !isHTTPURL(cfg.ListenBrainz.APIURL) {
+				return ErrInvalidURL{Backend: backend, URL: cfg.ListenBrainz.APIURL}
+			}
+
+			continue
+		}
+
+		account, ok := cfg.Account(backend)
+		if !ok {
+			return ErrUnknownBackend{Backend: backend}
+		}
+
+		if err := validateCredentials(account); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateRules(rules Rules) error {
+	if rules.MinDuration < 0 {
+		return ErrInvalidRule{Rule: "min_duration", Reason: "it can't be negative"}
+	}
+
+	if rules.Percentage <= 0 || rules.Percentage > 100 {
+		return ErrInvalidRule{Rule: "percentage", Reason: "it must be greater than 0 and at most 100"}
+	}
+
+	if rules.MaxDelay < 0 {
+		return ErrInvalidRule{Rule: "max_delay", Reason: "it can't be negative"}
+	}
+
+	if rules.UnknownDurationAfter < 0 {
+		return ErrInvalidRule{Rule: "unknown_duration_after", Reason: "it can't be negative"}
+	}
+
+	if rules.Mode != constants.ModeTimer && rules.Mode != constants.ModeBoundary {
+		return ErrInvalidRule{Rule: "mode", Reason: "it must be either timer or boundary"}
+	}
+
+	if rules.IdleTimeout <= 0 {
+		return ErrInvalidRule{Rule: "idle_timeout", Reason: "it must be positive"}
+	}
+
+	if rules.PrefetchWindow < 0 {
+		return ErrInvalidRule{Rule: "prefetch_window", Reason: "it can't be negative"}
+	}
+
+	return nil
+}
+
+func validateCredentials(creds Credentials) error {
+	if creds.APIKey == "" {
 		return ErrAPIKeyMissing
 	}
 
-	if cfg.Credentials.SharedSecret == "" {
+	if creds.SharedSecret == "" {
 		return ErrSharedSecretMissing
 	}
 
+	if !isHTTPURL(creds.APIURL) {
+		return ErrInvalidURL{Backend: creds.Name, URL: creds.APIURL}
+	}
+
+	if !isHTTPURL(creds.AuthURL) {
+		return ErrInvalidURL{Backend: creds.Name, URL: creds.AuthURL}
+	}
+
 	return nil
 }
+
+func isHTTPURL(v string) bool {
+	u, err := url.Parse(v)
+
+	return err == nil && u.IsAbs() && (u.Scheme == "http" || u.Scheme == "https")
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
+)
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		expected Rules
+		err      error
+	}{
+		{
+			name:     "defaults",
+			json:     `{}`,
+			expected: DefaultRules(),
+		},
+		{
+			name: "partially set",
+			json: `{"rules": {"percentage": 75, "unknown_duration_after": "3m"}}`,
+			expected: Rules{
+				MinDuration:          Duration(time.Second * 30),
+				Percentage:           75,
+				MaxDelay:             Duration(time.Minute * 4),
+				UnknownDurationAfter: Duration(time.Minute * 3),
+				Mode:                 constants.ModeTimer,
+				IdleTimeout:          Duration(time.Minute * 15),
+			},
+		},
+		{
+			name: "no cap",
+			json: `{"rules": {"min_duration": "1m30s", "max_delay": "0s"}}`,
+			expected: Rules{
+				MinDuration: Duration(time.Second * 90),
+				Percentage:  50,
+				Mode:        constants.ModeTimer,
+				IdleTimeout: Duration(time.Minute * 15),
+			},
+		},
+		{
+			name: "boundary mode",
+			json: `{"rules": {"mode": "boundary", "idle_timeout": "5m"}}`,
+			expected: Rules{
+				MinDuration: Duration(time.Second * 30),
+				Percentage:  50,
+				MaxDelay:    Duration(time.Minute * 4),
+				Mode:        constants.ModeBoundary,
+				IdleTimeout: Duration(time.Minute * 5),
+			},
+		},
+		{
+			name: "unknown mode",
+			json: `{"rules": {"mode": "whenever"}}`,
+			err:  ErrInvalidRule{Rule: "mode", Reason: "it must be either timer or boundary"},
+		},
+		{
+			name: "percentage out of range",
+			json: `{"rules": {"percentage": 120}}`,
+			err:  ErrInvalidRule{Rule: "percentage", Reason: "it must be greater than 0 and at most 100"},
+		},
+		{
+			name: "negative duration",
+			json: `{"rules": {"max_delay": "-1m"}}`,
+			err:  ErrInvalidRule{Rule: "max_delay", Reason: "it can't be negative"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := unmarshall(strings.NewReader(test.json))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = validateRules(cfg.Rules)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			if test.err == nil && cfg.Rules != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, cfg.Rules)
+			}
+		})
+	}
+}
+
+func TestRulesInvalidDuration(t *testing.T) {
+	if _, err := unmarshall(strings.NewReader(`{"rules": {"min_duration": "half a minute"}}`)); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestAccountNames(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "librefm"},
+		{name: "libre.fm"},
+		{name: "", err: ErrAccountNameMissing},
+		{name: "../session", err: ErrInvalidAccountName{Name: "../session"}},
+		{name: "libre/fm", err: ErrInvalidAccountName{Name: "libre/fm"}},
+		{name: `libre\fm`, err: ErrInvalidAccountName{Name: `libre\fm`}},
+		{name: "..", err: ErrInvalidAccountName{Name: ".."}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := Config{
+				DBFile:   "/var/cache/minidlna/files.db",
+				LogFile:  "/var/log/minidlna.log",
+				Backends: []string{constants.BackendListenBrainz},
+				Accounts: []Credentials{{Name: test.name}},
+				ListenBrainz: ListenBrainz{
+					Token:  "token",
+					APIURL: constants.ListenBrainzAPIURL,
+				},
+				Rules: DefaultRules(),
+			}
+
+			if err := validate(cfg); !errors.Is(err, test.err) {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
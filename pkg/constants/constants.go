@@ -4,7 +4,13 @@ const (
 	ContextKeyContainer = "container"
 	XDGConfigDir        = "XDG_CONFIG_HOME"
 	XDGCacheDIR         = "XDG_CACHE_HOME"
+	XDGStateDir         = "XDG_STATE_HOME"
 	APIBaseURL          = "https://ws.audioscrobbler.com/2.0/"
 	UserAPIBaseURL      = "https://www.last.fm/api"
+	ListenBrainzAPIURL  = "https://api.listenbrainz.org"
+	BackendLastFM       = "lastfm"
+	BackendListenBrainz = "listenbrainz"
 	MagicLogValue       = "Serving DetailID"
+	ModeTimer           = "timer"
+	ModeBoundary        = "boundary"
 )
@@ -4,11 +4,16 @@ import (
 	"database/sql"
 	"errors"
 
+	"github.com/dusnm/minidlna-scrobble/pkg/clock"
 	"github.com/dusnm/minidlna-scrobble/pkg/config"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
 	"github.com/dusnm/minidlna-scrobble/pkg/repositories/metadata"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/queue"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/auth"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/job"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/listenbrainz"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobble"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobbler"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/sessioncache"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/watcher"
 	"github.com/rs/zerolog"
@@ -17,15 +22,23 @@ import (
 
 type (
 	Container struct {
-		Cfg                 *config.Config
-		Logger              zerolog.Logger
-		db                  *sql.DB
-		authService         *auth.Service
-		sessionCacheService *sessioncache.Service
-		watcherService      *watcher.Service
-		scrobbleService     *scrobble.Service
-		jobService          *job.Service
-		metadataRepo        *metadata.Repository
+		Cfg    *config.Config
+		Logger zerolog.Logger
+		// Shared by the services that schedule work, can be
+		// replaced before they're created, e.g. when testing
+		Clock clock.Clock
+		db    *sql.DB
+		// Keyed by account name
+		authServices         map[string]*auth.Service
+		sessionCacheServices map[string]*sessioncache.Service
+		scrobbleServices     map[string]*scrobble.Service
+		watcherService       *watcher.Service
+		listenBrainzService  *listenbrainz.Service
+		scrobbler            *scrobbler.FanOut
+		jobService           *job.Service
+		metadataRepo         *metadata.Repository
+		queueRepo            *queue.Repository
+		historyRepo          *history.Repository
 	}
 )
 
@@ -36,7 +49,11 @@ func New(logLevel zerolog.Level) (*Container, error) {
 	}
 
 	return &Container{
-		Cfg: cfg,
+		Cfg:                  cfg,
+		Clock:                clock.Real{},
+		authServices:         make(map[string]*auth.Service),
+		sessionCacheServices: make(map[string]*sessioncache.Service),
+		scrobbleServices:     make(map[string]*scrobble.Service),
 		Logger: log.
 			Logger.
 			Level(logLevel).
@@ -56,5 +73,13 @@ func (c *Container) Close() error {
 		err = errors.Join(err, c.metadataRepo.Close())
 	}
 
+	if c.queueRepo != nil {
+		err = errors.Join(err, c.queueRepo.Close())
+	}
+
+	if c.historyRepo != nil {
+		err = errors.Join(err, c.historyRepo.Close())
+	}
+
 	return err
 }
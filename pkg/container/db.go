@@ -2,8 +2,12 @@ package container
 
 import (
 	"database/sql"
+	"path/filepath"
 
+	"github.com/dusnm/minidlna-scrobble/pkg/helpers"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
 	"github.com/dusnm/minidlna-scrobble/pkg/repositories/metadata"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/queue"
 	_ "github.com/glebarez/go-sqlite"
 )
 
@@ -48,3 +52,77 @@ func (c *Container) GetMetadataRepository() *metadata.Repository {
 
 	return c.metadataRepo
 }
+
+func (c *Container) GetQueueRepository() *queue.Repository {
+	if c.queueRepo == nil {
+		cacheDir, err := helpers.CacheDir()
+		if err != nil {
+			c.Logger.Fatal().Err(err).Msg("unable to access the cache directory")
+		}
+
+		db, err := sql.Open("sqlite", filepath.Join(cacheDir, "queue.db"))
+		if err != nil {
+			c.Logger.
+				Fatal().
+				Err(err).
+				Msg("error opening the queue database file")
+		}
+
+		// The queue is written to from multiple goroutines,
+		// serialize access to avoid locking errors
+		db.SetMaxOpenConns(1)
+
+		// The deprecated backend is the first of the backends,
+		// it's the one a queue from before there were several was for
+		queueRepo, err := queue.New(
+			db,
+			c.Cfg.Backends[0],
+			c.Logger.
+				With().
+				Str("repository", "queue").
+				Logger(),
+		)
+		if err != nil {
+			c.Logger.Fatal().Err(err).Msg("unable to create an instance of the queue repo")
+		}
+
+		c.queueRepo = queueRepo
+	}
+
+	return c.queueRepo
+}
+
+func (c *Container) GetHistoryRepository() *history.Repository {
+	if c.historyRepo == nil {
+		stateDir, err := helpers.StateDir()
+		if err != nil {
+			c.Logger.Fatal().Err(err).Msg("unable to access the state directory")
+		}
+
+		db, err := sql.Open("sqlite", filepath.Join(stateDir, "history.db"))
+		if err != nil {
+			c.Logger.
+				Fatal().
+				Err(err).
+				Msg("error opening the history database file")
+		}
+
+		// Written to from multiple goroutines, like the queue
+		db.SetMaxOpenConns(1)
+
+		historyRepo, err := history.New(
+			db,
+			c.Logger.
+				With().
+				Str("repository", "history").
+				Logger(),
+		)
+		if err != nil {
+			c.Logger.Fatal().Err(err).Msg("unable to create an instance of the history repo")
+		}
+
+		c.historyRepo = historyRepo
+	}
+
+	return c.historyRepo
+}
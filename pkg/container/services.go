@@ -1,24 +1,28 @@
 package container
 
 import (
+	"github.com/dusnm/minidlna-scrobble/pkg/config"
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/auth"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/job"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/listenbrainz"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobble"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobbler"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/sessioncache"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/watcher"
 )
 
-func (c *Container) GetAuthService() *auth.Service {
-	if c.authService == nil {
-		c.authService = auth.New(c.Cfg.Credentials)
+func (c *Container) GetAuthService(account string) *auth.Service {
+	if _, ok := c.authServices[account]; !ok {
+		c.authServices[account] = auth.New(c.getAccount(account))
 	}
 
-	return c.authService
+	return c.authServices[account]
 }
 
-func (c *Container) GetSessionCacheService() *sessioncache.Service {
-	if c.sessionCacheService == nil {
-		service, err := sessioncache.New()
+func (c *Container) GetSessionCacheService(account string) *sessioncache.Service {
+	if _, ok := c.sessionCacheServices[account]; !ok {
+		service, err := sessioncache.New(account)
 		if err != nil {
 			c.Logger.
 				Fatal().
@@ -26,10 +30,10 @@ func (c *Container) GetSessionCacheService() *sessioncache.Service {
 				Msg("unable to create an instance of session cache")
 		}
 
-		c.sessionCacheService = service
+		c.sessionCacheServices[account] = service
 	}
 
-	return c.sessionCacheService
+	return c.sessionCacheServices[account]
 }
 
 func (c *Container) GetWatcherService() *watcher.Service {
@@ -37,8 +41,10 @@ func (c *Container) GetWatcherService() *watcher.Service {
 		watcherService, err := watcher.New(
 			c.Cfg,
 			c.GetMetadataRepository(),
-			c.GetScrobbleService(),
+			c.GetHistoryRepository(),
+			c.GetScrobbler(),
 			c.GetJobService(),
+			c.Clock,
 			c.Logger.
 				With().
 				Str("service", "watcher").
@@ -57,21 +63,24 @@ func (c *Container) GetWatcherService() *watcher.Service {
 	return c.watcherService
 }
 
-func (c *Container) GetScrobbleService() *scrobble.Service {
-	if c.scrobbleService == nil {
-		c.scrobbleService = scrobble.New(
-			c.Cfg.Credentials,
-			c.GetSessionCacheService(),
+func (c *Container) GetScrobbleService(account string) *scrobble.Service {
+	if _, ok := c.scrobbleServices[account]; !ok {
+		c.scrobbleServices[account] = scrobble.New(
+			c.getAccount(account),
+			c.GetSessionCacheService(account),
 		)
 	}
 
-	return c.scrobbleService
+	return c.scrobbleServices[account]
 }
 
 func (c *Container) GetJobService() *job.Service {
 	if c.jobService == nil {
 		c.jobService = job.New(
-			c.GetScrobbleService(),
+			c.GetQueueRepository(),
+			c.GetHistoryRepository(),
+			c.GetScrobbler(),
+			c.Clock,
 			c.Logger.
 				With().
 				Str("service", "job").
@@ -81,3 +90,43 @@ func (c *Container) GetJobService() *job.Service {
 
 	return c.jobService
 }
+
+func (c *Container) GetListenBrainzService() *listenbrainz.Service {
+	if c.listenBrainzService == nil {
+		c.listenBrainzService = listenbrainz.New(c.Cfg.ListenBrainz)
+	}
+
+	return c.listenBrainzService
+}
+
+// GetScrobbler returns a scrobbler that submits to all configured backends
+func (c *Container) GetScrobbler() *scrobbler.FanOut {
+	if c.scrobbler == nil {
+		targets := make([]scrobbler.Scrobbler, 0, len(c.Cfg.Backends))
+		for _, backend := range c.Cfg.Backends {
+			if backend == constants.BackendListenBrainz {
+				targets = append(targets, c.GetListenBrainzService())
+				continue
+			}
+
+			// Anything else is an Audioscrobbler account
+			targets = append(targets, c.GetScrobbleService(backend))
+		}
+
+		c.scrobbler = scrobbler.NewFanOut(targets...)
+	}
+
+	return c.scrobbler
+}
+
+func (c *Container) getAccount(name string) config.Credentials {
+	account, ok := c.Cfg.Account(name)
+	if !ok {
+		c.Logger.
+			Fatal().
+			Str("account", name).
+			Msg("no such account in the configuration")
+	}
+
+	return account
+}
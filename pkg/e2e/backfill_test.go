@@ -0,0 +1,85 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/replay"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+	"github.com/dusnm/minidlna-scrobble/pkg/rules"
+)
+
+func TestBackfillTwice(t *testing.T) {
+	playedAt := time.Date(2025, time.March, 3, 20, 0, 0, 0, time.Local)
+	h := newHarness(t, playedAt.Add(time.Hour), colorOfTheFire)
+
+	// The same plays as the backfill command, from a log the watcher doesn't follow
+	backfill := func() int {
+		t.Helper()
+
+		serves, err := replay.Serves(strings.NewReader(fmt.Sprintf(
+			"[%s] upnphttp.c:1923: info: Serving DetailID: %d [%s]\n",
+			playedAt.Format("2006/01/02 15:04:05"),
+			colorOfTheFire.ID,
+			colorOfTheFire.Path,
+		)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		plays, _ := replay.Plays(
+			context.Background(),
+			serves,
+			h.container.GetMetadataRepository().GetByID,
+			rules.New(h.container.Cfg.Rules),
+			h.clock.Now(),
+		)
+
+		recorded := make([]history.Play, 0, len(plays))
+		for _, play := range plays {
+			recorded = append(recorded, history.Play{
+				DetailID: play.Serve.DetailID,
+				Path:     play.Serve.Path,
+				Track:    play.Track,
+			})
+		}
+
+		enqueued, err := h.container.GetJobService().Enqueue(context.Background(), recorded...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return enqueued
+	}
+
+	if enqueued := backfill(); enqueued != 1 {
+		t.Fatalf("expected the play to be enqueued, got %d", enqueued)
+	}
+
+	h.expectRequest(signed("track.scrobble", trackParams(colorOfTheFire, colorOfTheFire.Title, playedAt, 200)))
+	h.waitForEmptyQueue()
+
+	if enqueued := backfill(); enqueued != 0 {
+		t.Errorf("expected the scrobbled play to be left out, got %d enqueued", enqueued)
+	}
+
+	h.expectNoRequest(time.Millisecond * 100)
+
+	var plays []history.Play
+	eventually(t, "history", func() bool {
+		var err error
+		plays, err = h.container.GetHistoryRepository().Find(context.Background(), history.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return len(plays) == 1 && len(plays[0].Outcomes) == 1 && plays[0].Outcomes[0].Status == history.StatusSent
+	})
+
+	if plays[0].DetailID != colorOfTheFire.ID || plays[0].Path != colorOfTheFire.Path {
+		t.Errorf("unexpected play %+v", plays[0])
+	}
+}
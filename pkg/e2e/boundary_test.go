@@ -0,0 +1,107 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+)
+
+func TestScrobbleOnBoundary(t *testing.T) {
+	start := time.Date(2025, time.March, 2, 20, 0, 0, 0, time.Local)
+	h := newHarnessWithRules(
+		t,
+		start,
+		map[string]any{"mode": "boundary", "idle_timeout": "10m"},
+		colorOfTheFire,
+		telephasicWorkshop,
+		trianglesAndRhombuses,
+	)
+
+	// Nothing is scrobbled while the track plays, no matter how long
+	h.serve(colorOfTheFire)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+	h.clock.Advance(time.Second * 150)
+	h.expectNoRequest(time.Millisecond * 100)
+
+	// Until the next one starts, it played for long enough
+	skippedAt := h.clock.Now()
+	h.serve(telephasicWorkshop)
+	h.expectRequests(
+		signed("track.scrobble", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)),
+		signed("track.updateNowPlaying", trackParams(telephasicWorkshop, telephasicWorkshop.Title, skippedAt, 395)),
+	)
+
+	// Skipped after a minute
+	h.clock.Advance(time.Minute)
+	playedAt := h.clock.Now()
+	h.serve(trianglesAndRhombuses)
+	h.expectRequest(signed(
+		"track.updateNowPlaying",
+		trackParams(trianglesAndRhombuses, "Triangles & Rhombuses", playedAt, 111),
+	))
+	h.expectNoRequest(time.Millisecond * 100)
+
+	// Requested again while it played, e.g. to resume after a pause
+	h.clock.Advance(time.Second * 100)
+	h.serve(trianglesAndRhombuses)
+	h.expectNoRequest(time.Millisecond * 100)
+
+	// The last track counts once nothing else started in time,
+	// it played at least until it was requested again
+	h.waitForTimer(playedAt.Add(time.Minute * 10))
+	h.clock.Advance(time.Minute*10 - time.Second*100)
+	h.expectRequest(signed(
+		"track.scrobble",
+		trackParams(trianglesAndRhombuses, "Triangles & Rhombuses", playedAt, 111),
+	))
+
+	h.waitForEmptyQueue()
+	h.expectNoRequest(time.Millisecond * 100)
+}
+
+func TestScrobbleOnBoundaryIdleTimeout(t *testing.T) {
+	start := time.Date(2025, time.March, 2, 21, 0, 0, 0, time.Local)
+	h := newHarnessWithRules(
+		t,
+		start,
+		map[string]any{"mode": "boundary", "idle_timeout": "1m"},
+		colorOfTheFire,
+	)
+
+	// Stopped early with nothing played after it
+	h.serve(colorOfTheFire)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+
+	h.waitForTimer(start.Add(time.Minute))
+	h.clock.Advance(time.Hour)
+	h.expectNoRequest(time.Millisecond * 200)
+}
+
+func TestScrobbleOnBoundarySilence(t *testing.T) {
+	start := time.Date(2025, time.March, 2, 22, 0, 0, 0, time.Local)
+	h := newHarnessWithRules(
+		t,
+		start,
+		map[string]any{"mode": "boundary", "idle_timeout": "10m"},
+		colorOfTheFire,
+	)
+
+	// A single serve followed by silence, the renderer could've been stopped right away
+	h.serve(colorOfTheFire)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+
+	h.waitForTimer(start.Add(time.Minute * 10))
+	h.clock.Advance(time.Minute * 10)
+	h.expectNoRequest(time.Millisecond * 200)
+
+	eventually(t, "skipped play", func() bool {
+		plays, err := h.container.GetHistoryRepository().Find(context.Background(), history.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return len(plays) == 1 && plays[0].Skipped == history.ReasonNotPlayed
+	})
+}
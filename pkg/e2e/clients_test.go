@@ -0,0 +1,47 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScrobblePerClient(t *testing.T) {
+	start := time.Date(2025, time.March, 3, 20, 0, 0, 0, time.Local)
+	h := newHarness(t, start, colorOfTheFire, telephasicWorkshop, trianglesAndRhombuses)
+
+	h.serveTo("192.168.1.23", colorOfTheFire)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+	h.waitForTimer(start.Add(time.Second * 100))
+
+	// Someone else starts listening on another renderer
+	h.clock.Advance(time.Second * 20)
+	otherAt := h.clock.Now()
+	h.serveTo("192.168.1.40", telephasicWorkshop)
+	h.expectRequest(signed(
+		"track.updateNowPlaying",
+		trackParams(telephasicWorkshop, telephasicWorkshop.Title, otherAt, 395),
+	))
+	h.waitForTimer(otherAt.Add(time.Millisecond * 197500))
+
+	// Both are scrobbled
+	h.clock.Advance(time.Second * 80)
+	h.expectRequest(signed("track.scrobble", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+
+	// A track change of the first client doesn't affect the other one
+	changedAt := h.clock.Now()
+	h.serveTo("192.168.1.23", trianglesAndRhombuses)
+	h.expectRequest(signed(
+		"track.updateNowPlaying",
+		trackParams(trianglesAndRhombuses, "Triangles & Rhombuses", changedAt, 111),
+	))
+	h.waitForTimer(changedAt.Add(time.Millisecond * 55250))
+
+	h.clock.Advance(time.Millisecond * 55250)
+	h.expectRequest(signed(
+		"track.scrobble",
+		trackParams(trianglesAndRhombuses, "Triangles & Rhombuses", changedAt, 111),
+	))
+
+	h.clock.Advance(time.Minute * 2)
+	h.expectRequest(signed("track.scrobble", trackParams(telephasicWorkshop, telephasicWorkshop.Title, otherAt, 395)))
+}
@@ -0,0 +1,417 @@
+// Package e2e runs the scrobble command's services against a synthetic
+// minidlna database and log file, and a fake last.fm API.
+package e2e
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/clock"
+	"github.com/dusnm/minidlna-scrobble/pkg/container"
+	_ "github.com/glebarez/go-sqlite"
+	"github.com/rs/zerolog"
+)
+
+const (
+	apiKey       = "test-api-key"
+	sharedSecret = "test-shared-secret"
+	sessionKey   = "test-session-key"
+
+	// How long to wait for something the services do asynchronously
+	waitTimeout = time.Second * 5
+)
+
+type (
+	// detail is a row of minidlna's DETAILS table
+	detail struct {
+		ID       int
+		Path     string
+		Artist   string
+		Album    string
+		Title    string
+		Duration string
+		Track    int
+	}
+
+	// logLine is a message logged at the time
+	logLine struct {
+		at  time.Time
+		msg string
+	}
+
+	harness struct {
+		t         *testing.T
+		logFile   string
+		clock     *clock.Fake
+		lastFM    *fakeLastFM
+		container *container.Container
+	}
+
+	// fakeLastFM records the requests made to it, and accepts everything
+	fakeLastFM struct {
+		server   *httptest.Server
+		requests chan url.Values
+	}
+)
+
+// newHarness sets up the configuration, database, log file and last.fm session,
+// and starts the services the same way the scrobble command does.
+func newHarness(t *testing.T, now time.Time, details ...detail) *harness {
+	return newHarnessWithRules(t, now, nil, details...)
+}
+
+// newHarnessWithRules is newHarness with the rules object of the configuration set.
+func newHarnessWithRules(t *testing.T, now time.Time, rules map[string]any, details ...detail) *harness {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+
+	h := &harness{
+		t:       t,
+		logFile: filepath.Join(dir, "minidlna.log"),
+		clock:   clock.NewFake(now),
+		lastFM:  newFakeLastFM(t),
+	}
+
+	dbFile := filepath.Join(dir, "files.db")
+	createDB(t, dbFile, details)
+
+	if err := os.WriteFile(h.logFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := map[string]any{
+		"db_file":  dbFile,
+		"log_file": h.logFile,
+		"credentials": map[string]any{
+			"api_key":       apiKey,
+			"shared_secret": sharedSecret,
+			"api_url":       h.lastFM.server.URL + "/2.0/",
+		},
+	}
+
+	if rules != nil {
+		cfg["rules"] = rules
+	}
+
+	writeJSON(t, filepath.Join(dir, "config", "minidlna-scrobbler", "config.json"), cfg)
+
+	writeJSON(t, filepath.Join(dir, "cache", "minidlna-scrobbler", "session.json"), map[string]any{
+		"session": map[string]any{
+			"name": "test",
+			"key":  sessionKey,
+		},
+	})
+
+	c, err := container.New(zerolog.Disabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Clock = h.clock
+	h.container = c
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		c.Close()
+	})
+
+	c.GetJobService().Work(ctx)
+	if err = c.GetWatcherService().Watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+// serve logs that minidlna served the track, at the current time.
+func (h *harness) serve(d detail) {
+	h.t.Helper()
+
+	h.log(fmt.Sprintf("upnphttp.c:1923: info: Serving DetailID: %d [%s]", d.ID, d.Path))
+}
+
+// serveTo logs that minidlna served the track to the client, the
+// way it does when connections are logged at the debug level.
+func (h *harness) serveTo(addr string, d detail) {
+	h.t.Helper()
+
+	h.log(
+		fmt.Sprintf("minidlna.c:1231: debug: HTTP connection from %s:51544", addr),
+		fmt.Sprintf("upnphttp.c:1923: info: Serving DetailID: %d [%s]", d.ID, d.Path),
+	)
+}
+
+// log appends the messages to the log, at the current time.
+func (h *harness) log(messages ...string) {
+	h.t.Helper()
+
+	lines := make([]logLine, 0, len(messages))
+	for _, msg := range messages {
+		lines = append(lines, logLine{at: h.clock.Now(), msg: msg})
+	}
+
+	h.write(lines...)
+}
+
+// write appends the lines to the log in a single write.
+func (h *harness) write(lines ...logLine) {
+	h.t.Helper()
+
+	var buff strings.Builder
+	for _, line := range lines {
+		fmt.Fprintf(&buff, "[%s] %s\n", line.at.Format("2006/01/02 15:04:05"), line.msg)
+	}
+
+	f, err := os.OpenFile(h.logFile, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if _, err = f.WriteString(buff.String()); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// expectRequest fails the test unless the next request to last.fm is the expected one.
+func (h *harness) expectRequest(expected url.Values) {
+	h.t.Helper()
+
+	select {
+	case actual := <-h.lastFM.requests:
+		if !equalValues(actual, expected) {
+			h.t.Fatalf("expected request\n%v\ngot\n%v", expected, actual)
+		}
+	case <-time.After(waitTimeout):
+		h.t.Fatalf("expected request %v, got none", expected)
+	}
+}
+
+// expectRequests fails the test unless the next requests to last.fm
+// are the expected ones, in any order.
+func (h *harness) expectRequests(expected ...url.Values) {
+	h.t.Helper()
+
+	for range expected {
+		select {
+		case actual := <-h.lastFM.requests:
+			i := slices.IndexFunc(expected, func(v url.Values) bool {
+				return equalValues(actual, v)
+			})
+			if i < 0 {
+				h.t.Fatalf("unexpected request %v, expected one of %v", actual, expected)
+			}
+
+			expected = slices.Delete(expected, i, i+1)
+		case <-time.After(waitTimeout):
+			h.t.Fatalf("expected requests %v, got none", expected)
+		}
+	}
+}
+
+// expectNoRequest fails the test if a request is made to last.fm in the meantime.
+func (h *harness) expectNoRequest(wait time.Duration) {
+	h.t.Helper()
+
+	select {
+	case actual := <-h.lastFM.requests:
+		h.t.Fatalf("expected no request, got %v", actual)
+	case <-time.After(wait):
+	}
+}
+
+// waitForTimer blocks until something waits for the clock to reach the time.
+func (h *harness) waitForTimer(at time.Time) {
+	h.t.Helper()
+
+	eventually(h.t, "timer at "+at.String(), func() bool {
+		return slices.ContainsFunc(h.clock.Pending(), at.Equal)
+	})
+}
+
+// waitForEmptyQueue blocks until nothing is left to be sent.
+func (h *harness) waitForEmptyQueue() {
+	h.t.Helper()
+
+	eventually(h.t, "empty queue", func() bool {
+		_, pending, err := h.container.GetQueueRepository().NextDue(context.Background(), "lastfm")
+		if err != nil {
+			h.t.Fatal(err)
+		}
+
+		return !pending
+	})
+}
+
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func newFakeLastFM(t *testing.T) *fakeLastFM {
+	f := &fakeLastFM{
+		requests: make(chan url.Values, 64),
+	}
+
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/2.0/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		f.requests <- r.PostForm
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.PostForm.Get("method") {
+		case "track.updateNowPlaying":
+			fmt.Fprint(w, `{"nowplaying":{"ignoredMessage":{"code":"0","#text":""}}}`)
+		case "track.scrobble":
+			fmt.Fprint(
+				w,
+				`{"scrobbles":{"scrobble":{"ignoredMessage":{"code":"0","#text":""}},"@attr":{"accepted":1,"ignored":0}}}`,
+			)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":3,"message":"Invalid Method"}`)
+		}
+	}))
+
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+func createDB(t *testing.T, path string, details []detail) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	// The columns minidlna creates that are read by the application
+	_, err = db.Exec(`CREATE TABLE DETAILS (
+		ID INTEGER PRIMARY KEY AUTOINCREMENT,
+		PATH TEXT DEFAULT NULL,
+		TITLE TEXT COLLATE NOCASE,
+		ARTIST TEXT COLLATE NOCASE,
+		ALBUM TEXT COLLATE NOCASE,
+		DURATION TEXT,
+		TRACK INTEGER
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range details {
+		_, err = db.Exec(
+			"INSERT INTO DETAILS (ID, PATH, TITLE, ARTIST, ALBUM, DURATION, TRACK) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			d.ID,
+			d.Path,
+			d.Title,
+			d.Artist,
+			d.Album,
+			d.Duration,
+			d.Track,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeJSON(t *testing.T, path string, v any) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	buff, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(path, buff, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sign calculates api_sig as documented by last.fm, independently of the application:
+// the parameters except format, sorted by name and concatenated, followed by the secret.
+func sign(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if key != "format" {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+
+	s := ""
+	for _, key := range keys {
+		s += key + params.Get(key)
+	}
+
+	sum := md5.Sum([]byte(s + sharedSecret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// signed completes the parameters of an authenticated API call.
+func signed(method string, params url.Values) url.Values {
+	params.Set("method", method)
+	params.Set("api_key", apiKey)
+	params.Set("sk", sessionKey)
+	params.Set("api_sig", sign(params))
+	params.Set("format", "json")
+
+	return params
+}
+
+func equalValues(a, b url.Values) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, values := range a {
+		if !slices.Equal(values, b[key]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,68 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+)
+
+func TestHistory(t *testing.T) {
+	start := time.Date(2025, time.March, 2, 20, 0, 0, 0, time.Local)
+	h := newHarness(t, start, colorOfTheFire, telephasicWorkshop)
+
+	h.serve(colorOfTheFire)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+
+	h.waitForTimer(start.Add(time.Second * 100))
+	h.clock.Advance(time.Second * 100)
+	h.expectRequest(signed("track.scrobble", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+
+	// Changed before half of it played
+	skippedAt := h.clock.Now()
+	h.serve(telephasicWorkshop)
+	h.expectRequest(signed(
+		"track.updateNowPlaying",
+		trackParams(telephasicWorkshop, telephasicWorkshop.Title, skippedAt, 395),
+	))
+
+	h.waitForTimer(skippedAt.Add(time.Millisecond * 197500))
+	h.clock.Advance(time.Minute)
+	h.serve(colorOfTheFire)
+	h.expectRequest(signed(
+		"track.updateNowPlaying",
+		trackParams(colorOfTheFire, colorOfTheFire.Title, h.clock.Now(), 200),
+	))
+
+	var plays []history.Play
+	eventually(t, "history", func() bool {
+		var err error
+		plays, err = h.container.GetHistoryRepository().Find(context.Background(), history.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return len(plays) == 3 && plays[1].Skipped != "" &&
+			len(plays[0].Outcomes) > 0 && plays[0].Outcomes[0].Status == history.StatusSent &&
+			len(plays[2].Outcomes) > 0
+	})
+
+	first := plays[0]
+	if first.DetailID != colorOfTheFire.ID || first.Path != colorOfTheFire.Path || !first.Track.Timestamp.Equal(start) {
+		t.Errorf("unexpected play %+v", first)
+	}
+
+	if len(first.Outcomes) != 1 || first.Outcomes[0].Target != "lastfm" {
+		t.Errorf("unexpected outcomes %+v", first.Outcomes)
+	}
+
+	skipped := plays[1]
+	if skipped.DetailID != telephasicWorkshop.ID || skipped.Skipped != history.ReasonNotPlayed || len(skipped.Outcomes) != 0 {
+		t.Errorf("unexpected skipped play %+v", skipped)
+	}
+
+	if last := plays[2]; last.Outcomes[0].Status != history.StatusPending {
+		t.Errorf("unexpected outcomes of the playing track %+v", last.Outcomes)
+	}
+}
@@ -0,0 +1,39 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrefetchIsUpNext(t *testing.T) {
+	start := time.Date(2025, time.March, 2, 19, 0, 0, 0, time.Local)
+	h := newHarnessWithRules(
+		t,
+		start,
+		map[string]any{"prefetch_window": "10s"},
+		colorOfTheFire,
+		telephasicWorkshop,
+	)
+
+	h.serve(colorOfTheFire)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+	h.waitForTimer(start.Add(time.Second * 100))
+
+	// The renderer requests the next track right away, it doesn't replace the current one
+	h.clock.Advance(time.Second * 3)
+	h.serve(telephasicWorkshop)
+	h.expectNoRequest(time.Millisecond * 100)
+
+	h.clock.Advance(time.Second * 97)
+	h.expectRequest(signed("track.scrobble", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+
+	// It starts playing when the current track ends
+	next := start.Add(time.Second * 200)
+	h.waitForTimer(next)
+	h.clock.Advance(time.Second * 100)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(telephasicWorkshop, telephasicWorkshop.Title, next, 395)))
+
+	h.waitForTimer(next.Add(time.Millisecond * 197500))
+	h.clock.Advance(time.Millisecond * 197500)
+	h.expectRequest(signed("track.scrobble", trackParams(telephasicWorkshop, telephasicWorkshop.Title, next, 395)))
+}
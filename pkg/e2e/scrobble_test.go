@@ -0,0 +1,247 @@
+package e2e
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	colorOfTheFire = detail{
+		ID:       1529,
+		Path:     "/srv/music/Boards of Canada/Music Has the Right to Children/03 - The Color of the Fire.flac",
+		Artist:   "Boards of Canada",
+		Album:    "Music Has the Right to Children",
+		Title:    "The Color of the Fire",
+		Duration: "0:03:20.000",
+		Track:    3,
+	}
+
+	telephasicWorkshop = detail{
+		ID:       1531,
+		Path:     "/srv/music/Boards of Canada/Music Has the Right to Children/04 - Telephasic Workshop.flac",
+		Artist:   "Boards of Canada",
+		Album:    "Music Has the Right to Children",
+		Title:    "Telephasic Workshop",
+		Duration: "0:06:35.000",
+		Track:    4,
+	}
+
+	trianglesAndRhombuses = detail{
+		ID:       1532,
+		Path:     "/srv/music/Boards of Canada/Music Has the Right to Children/05 - Triangles & Rhombuses.flac",
+		Artist:   "Boards of Canada",
+		Album:    "Music Has the Right to Children",
+		Title:    "Triangles &amp;amp; Rhombuses",
+		Duration: "0:01:50.500",
+		Track:    5,
+	}
+)
+
+// trackParams are the parameters of the track, played at the time.
+func trackParams(d detail, title string, playedAt time.Time, duration int) url.Values {
+	return url.Values{
+		"artist":      {d.Artist},
+		"track":       {title},
+		"album":       {d.Album},
+		"timestamp":   {strconv.FormatInt(playedAt.Unix(), 10)},
+		"duration":    {strconv.Itoa(duration)},
+		"trackNumber": {strconv.Itoa(d.Track)},
+	}
+}
+
+func TestScrobble(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 20, 15, 1, 0, time.Local)
+	h := newHarness(t, start, colorOfTheFire, telephasicWorkshop, trianglesAndRhombuses)
+
+	// Played long enough, half of the track
+	h.serve(colorOfTheFire)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+
+	h.waitForTimer(start.Add(time.Second * 100))
+	h.clock.Advance(time.Second * 100)
+	h.expectRequest(signed("track.scrobble", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+
+	// Skipped after 30 seconds, half of the track is over 3 minutes
+	h.clock.Advance(time.Second * 10)
+	skippedAt := h.clock.Now()
+	h.serve(telephasicWorkshop)
+	h.expectRequest(signed(
+		"track.updateNowPlaying",
+		trackParams(telephasicWorkshop, telephasicWorkshop.Title, skippedAt, 395),
+	))
+
+	h.waitForTimer(skippedAt.Add(time.Millisecond * 197500))
+	h.clock.Advance(time.Second * 30)
+
+	// The escaped ampersand is fixed, and the duration rounded
+	playedAt := h.clock.Now()
+	h.serve(trianglesAndRhombuses)
+	h.expectRequest(signed(
+		"track.updateNowPlaying",
+		trackParams(trianglesAndRhombuses, "Triangles & Rhombuses", playedAt, 111),
+	))
+
+	h.waitForTimer(playedAt.Add(time.Millisecond * 55250))
+	h.clock.Advance(time.Millisecond * 55250)
+	h.expectRequest(signed(
+		"track.scrobble",
+		trackParams(trianglesAndRhombuses, "Triangles & Rhombuses", playedAt, 111),
+	))
+
+	// Nothing is left of the skipped track
+	h.waitForEmptyQueue()
+	h.clock.Advance(time.Minute * 10)
+	h.expectNoRequest(time.Millisecond * 200)
+}
+
+func TestScrobbleDelayIsCapped(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 21, 0, 0, 0, time.Local)
+	long := detail{
+		ID:       2001,
+		Path:     "/srv/music/The Orb/Adventures Beyond the Ultraworld/01 - Little Fluffy Clouds.flac",
+		Artist:   "The Orb",
+		Album:    "Adventures Beyond the Ultraworld",
+		Title:    "Little Fluffy Clouds",
+		Duration: "0:10:00.000",
+		Track:    1,
+	}
+
+	h := newHarness(t, start, long)
+	h.serve(long)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(long, long.Title, start, 600)))
+
+	// 4 minutes, not half of the track
+	h.waitForTimer(start.Add(time.Minute * 4))
+	h.clock.Advance(time.Minute*4 - time.Second)
+	h.expectNoRequest(time.Millisecond * 100)
+
+	h.clock.Advance(time.Second)
+	h.expectRequest(signed("track.scrobble", trackParams(long, long.Title, start, 600)))
+}
+
+func TestScrobbleSkipsShortTracks(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 22, 0, 0, 0, time.Local)
+	short := detail{
+		ID:       3001,
+		Path:     "/srv/music/Aphex Twin/Drukqs/03 - Jynweythek Ylow.flac",
+		Artist:   "Aphex Twin",
+		Album:    "Drukqs",
+		Title:    "Jynweythek Ylow",
+		Duration: "0:00:30.000",
+		Track:    3,
+	}
+
+	h := newHarness(t, start, short, colorOfTheFire)
+
+	// Now playing is still sent, the track just isn't scrobbled
+	h.serve(short)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(short, short.Title, start, 30)))
+
+	// Plays are handled in order, so the short one is done with once the next one is
+	h.clock.Advance(time.Minute)
+	next := h.clock.Now()
+	h.serve(colorOfTheFire)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(colorOfTheFire, colorOfTheFire.Title, next, 200)))
+
+	h.waitForTimer(next.Add(time.Second * 100))
+	h.clock.Advance(time.Second * 100)
+	h.expectRequest(signed("track.scrobble", trackParams(colorOfTheFire, colorOfTheFire.Title, next, 200)))
+
+	h.waitForEmptyQueue()
+	h.expectNoRequest(time.Millisecond * 100)
+}
+
+func TestScrobbleRepeatedServes(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 23, 0, 0, 0, time.Local)
+	h := newHarness(t, start, colorOfTheFire)
+
+	h.serve(colorOfTheFire)
+	h.expectRequest(signed("track.updateNowPlaying", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+	h.waitForTimer(start.Add(time.Second * 100))
+
+	// The client probing the file and requesting it again doesn't restart the play
+	h.clock.Advance(time.Second * 2)
+	h.serve(colorOfTheFire)
+	h.clock.Advance(time.Second * 40)
+	h.serve(colorOfTheFire)
+	h.expectNoRequest(time.Millisecond * 100)
+
+	h.clock.Advance(time.Second * 58)
+	h.expectRequest(signed("track.scrobble", trackParams(colorOfTheFire, colorOfTheFire.Title, start, 200)))
+
+	// Played again once it ended
+	h.clock.Advance(time.Second * 100)
+	replayedAt := h.clock.Now()
+	h.serve(colorOfTheFire)
+	h.expectRequest(signed(
+		"track.updateNowPlaying",
+		trackParams(colorOfTheFire, colorOfTheFire.Title, replayedAt, 200),
+	))
+
+	h.waitForTimer(replayedAt.Add(time.Second * 100))
+	h.clock.Advance(time.Second * 100)
+	h.expectRequest(signed("track.scrobble", trackParams(colorOfTheFire, colorOfTheFire.Title, replayedAt, 200)))
+}
+
+func TestScrobbleBacklog(t *testing.T) {
+	start := time.Date(2025, time.March, 2, 20, 0, 0, 0, time.Local)
+	h := newHarness(t, start, colorOfTheFire, telephasicWorkshop, trianglesAndRhombuses)
+
+	// Read at once, like the lines written while the application wasn't running
+	serve := func(at time.Time, d detail) logLine {
+		return logLine{at: at, msg: fmt.Sprintf("upnphttp.c:1923: info: Serving DetailID: %d [%s]", d.ID, d.Path)}
+	}
+
+	playedAt := start.Add(-time.Minute * 20)
+	skippedAt := playedAt.Add(time.Minute * 4)
+	lastAt := skippedAt.Add(time.Second * 30)
+	h.write(
+		serve(playedAt, colorOfTheFire),
+		serve(skippedAt, telephasicWorkshop),
+		serve(lastAt, trianglesAndRhombuses),
+	)
+
+	// Played for 4 minutes and to the end, the skipped track for 30 seconds.
+	// The tracks ended long ago, so none of them is playing now.
+	expected := map[string]string{
+		strconv.FormatInt(playedAt.Unix(), 10): colorOfTheFire.Title,
+		strconv.FormatInt(lastAt.Unix(), 10):   "Triangles & Rhombuses",
+	}
+
+	for len(expected) > 0 {
+		select {
+		case actual := <-h.lastFM.requests:
+			if method := actual.Get("method"); method != "track.scrobble" {
+				t.Fatalf("expected only scrobbles, got %s %v", method, actual)
+			}
+
+			for i := 0; ; i++ {
+				suffix := fmt.Sprintf("[%d]", i)
+				if !actual.Has("timestamp[0]") {
+					suffix = ""
+				}
+
+				timestamp := actual.Get("timestamp" + suffix)
+				if timestamp == "" {
+					break
+				}
+
+				if title, ok := expected[timestamp]; !ok || actual.Get("track"+suffix) != title {
+					t.Fatalf("unexpected scrobble of %s at %s", actual.Get("track"+suffix), timestamp)
+				}
+
+				delete(expected, timestamp)
+				if suffix == "" {
+					break
+				}
+			}
+		case <-time.After(waitTimeout):
+			t.Fatalf("expected scrobbles %v, got none", expected)
+		}
+	}
+
+	h.expectNoRequest(time.Millisecond * 200)
+}
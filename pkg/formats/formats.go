@@ -0,0 +1,135 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+)
+
+// Ratings of the Audioscrobbler/Rockbox log
+const (
+	RatingListened = "L"
+	RatingSkipped  = "S"
+)
+
+type (
+	// Play is a play as written to and read from the files.
+	Play struct {
+		Track models.Track
+		// The play was cut short, it doesn't count as a listen
+		Skipped bool
+	}
+
+	// jsonPlay is how a play is written as JSON, the duration is in seconds.
+	jsonPlay struct {
+		PlayedAt    time.Time `json:"played_at"`
+		Artist      string    `json:"artist"`
+		Track       string    `json:"track"`
+		Album       string    `json:"album"`
+		TrackNumber int       `json:"track_number"`
+		Duration    int64     `json:"duration"`
+		Skipped     bool      `json:"skipped"`
+	}
+)
+
+// The columns of the CSV files
+var csvHeader = []string{"played_at", "artist", "track", "album", "track_number", "duration", "skipped"}
+
+// WriteCSV writes the plays with a header, times are in RFC 3339 and durations in seconds.
+func WriteCSV(w io.Writer, plays []Play) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, play := range plays {
+		err := cw.Write([]string{
+			play.Track.Timestamp.Format(time.RFC3339),
+			play.Track.Artist,
+			play.Track.Name,
+			play.Track.Album,
+			strconv.Itoa(play.Track.Number),
+			strconv.FormatInt(seconds(play.Track.Duration), 10),
+			strconv.FormatBool(play.Skipped),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// WriteJSONLines writes every play as a JSON object on its own line.
+func WriteJSONLines(w io.Writer, plays []Play) error {
+	encoder := json.NewEncoder(w)
+	for _, play := range plays {
+		err := encoder.Encode(jsonPlay{
+			PlayedAt:    play.Track.Timestamp,
+			Artist:      play.Track.Artist,
+			Track:       play.Track.Name,
+			Album:       play.Track.Album,
+			TrackNumber: play.Track.Number,
+			Duration:    seconds(play.Track.Duration),
+			Skipped:     play.Skipped,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteScrobblerLog writes the plays in the .scrobbler.log format of Rockbox and other
+// portable players, as described by the Audioscrobbler portable player specification.
+// Times are written in UTC, the client identifies the application that wrote the log.
+func WriteScrobblerLog(w io.Writer, plays []Play, client string) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#AUDIOSCROBBLER/1.1\n")
+	bw.WriteString("#TZ/UTC\n")
+	bw.WriteString("#CLIENT/" + logField(client) + "\n")
+
+	for _, play := range plays {
+		number := ""
+		if play.Track.Number > 0 {
+			number = strconv.Itoa(play.Track.Number)
+		}
+
+		rating := RatingListened
+		if play.Skipped {
+			rating = RatingSkipped
+		}
+
+		// The last field is the MusicBrainz track ID, which isn't known
+		bw.WriteString(strings.Join([]string{
+			logField(play.Track.Artist),
+			logField(play.Track.Album),
+			logField(play.Track.Name),
+			number,
+			strconv.FormatInt(seconds(play.Track.Duration), 10),
+			rating,
+			strconv.FormatInt(play.Track.Timestamp.Unix(), 10),
+			"",
+		}, "\t") + "\n")
+	}
+
+	return bw.Flush()
+}
+
+// logField keeps the value from breaking the tab separated lines of the log.
+func logField(v string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(v)
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(d.Round(time.Second).Seconds())
+}
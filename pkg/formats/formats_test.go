@@ -0,0 +1,74 @@
+package formats
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+)
+
+var plays = []Play{
+	{
+		Track: models.Track{
+			Artist:    "Boards of Canada",
+			Name:      "Roygbiv",
+			Album:     "Music Has the Right to Children",
+			Number:    7,
+			Duration:  time.Millisecond * 151400,
+			Timestamp: time.Date(2025, time.March, 1, 20, 15, 1, 0, time.UTC),
+		},
+	},
+	{
+		Track: models.Track{
+			Artist:    "Aphex Twin",
+			Name:      "Xtal,\tremastered",
+			Timestamp: time.Date(2025, time.March, 1, 20, 18, 0, 0, time.UTC),
+		},
+		Skipped: true,
+	},
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, plays); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "played_at,artist,track,album,track_number,duration,skipped\n" +
+		"2025-03-01T20:15:01Z,Boards of Canada,Roygbiv,Music Has the Right to Children,7,151,false\n" +
+		"2025-03-01T20:18:00Z,Aphex Twin,\"Xtal,\tremastered\",,0,0,true\n"
+
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestWriteJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSONLines(&buf, plays[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"played_at":"2025-03-01T20:15:01Z","artist":"Boards of Canada","track":"Roygbiv",` +
+		`"album":"Music Has the Right to Children","track_number":7,"duration":151,"skipped":false}` + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestWriteScrobblerLog(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteScrobblerLog(&buf, plays, "minidlna-scrobble 1.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "#AUDIOSCROBBLER/1.1\n#TZ/UTC\n#CLIENT/minidlna-scrobble 1.0\n" +
+		"Boards of Canada\tMusic Has the Right to Children\tRoygbiv\t7\t151\tL\t1740860101\t\n" +
+		"Aphex Twin\t\tXtal, remastered\t\t0\tS\t1740860280\t\n"
+
+	if buf.String() != expected {
+		t.Errorf("expected\n%q\ngot\n%q", expected, buf.String())
+	}
+}
@@ -0,0 +1,254 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/helpers"
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+)
+
+var ErrMissingColumn = errors.New("the csv file must have the played_at, artist and track columns")
+
+type (
+	// ErrInvalidLine is returned for a line of a file that can't be read as a play.
+	ErrInvalidLine struct {
+		Line   int
+		Reason string
+	}
+)
+
+func (e ErrInvalidLine) Error() string {
+	return fmt.Sprintf("invalid line %d: %s", e.Line, e.Reason)
+}
+
+// ReadScrobblerLog reads the plays of a .scrobbler.log. Logs of players without
+// a time zone setting have the local time written as if it were UTC, which is undone.
+func ReadScrobblerLog(r io.Reader) ([]Play, error) {
+	var (
+		plays   = make([]Play, 0)
+		scanner = bufio.NewScanner(r)
+		utc     = false
+		lineNo  = 0
+	)
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			if line == "#TZ/UTC" {
+				utc = true
+			}
+
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return nil, ErrInvalidLine{Line: lineNo, Reason: "expected at least 7 tab separated fields"}
+		}
+
+		timestamp, err := strconv.ParseInt(fields[6], 10, 64)
+		if err != nil {
+			return nil, ErrInvalidLine{Line: lineNo, Reason: "invalid timestamp"}
+		}
+
+		playedAt := time.Unix(timestamp, 0)
+		if !utc {
+			wall := playedAt.UTC()
+			playedAt = time.Date(
+				wall.Year(), wall.Month(), wall.Day(),
+				wall.Hour(), wall.Minute(), wall.Second(), 0,
+				time.Local,
+			)
+		}
+
+		play, err := newPlay(fields[0], fields[2], fields[1], fields[3], fields[4], playedAt)
+		if err != nil {
+			return nil, ErrInvalidLine{Line: lineNo, Reason: err.Error()}
+		}
+
+		switch fields[5] {
+		case RatingListened:
+		case RatingSkipped:
+			play.Skipped = true
+		default:
+			return nil, ErrInvalidLine{Line: lineNo, Reason: "the rating must be either L or S"}
+		}
+
+		plays = append(plays, play)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return plays, nil
+}
+
+// ReadCSV reads the plays of a CSV file with a header. The columns are those written by
+// WriteCSV, in any order, of which only played_at, artist and track are required.
+// The time can also be given as a unix timestamp, or a local time like 2025-01-31 18:00.
+func ReadCSV(r io.Reader) ([]Play, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return []Play{}, nil
+		}
+
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, required := range csvHeader[:3] {
+		if _, ok := columns[required]; !ok {
+			return nil, ErrMissingColumn
+		}
+	}
+
+	plays := make([]Play, 0)
+	for {
+		record, err := cr.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+		column := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+
+			return strings.TrimSpace(record[i])
+		}
+
+		playedAt, err := parsePlayedAt(column("played_at"))
+		if err != nil {
+			return nil, ErrInvalidLine{Line: line, Reason: "invalid played_at"}
+		}
+
+		play, err := newPlay(
+			column("artist"),
+			column("track"),
+			column("album"),
+			column("track_number"),
+			column("duration"),
+			playedAt,
+		)
+		if err != nil {
+			return nil, ErrInvalidLine{Line: line, Reason: err.Error()}
+		}
+
+		if skipped := column("skipped"); skipped != "" {
+			play.Skipped, err = strconv.ParseBool(skipped)
+			if err != nil {
+				return nil, ErrInvalidLine{Line: line, Reason: "skipped must be either true or false"}
+			}
+		}
+
+		plays = append(plays, play)
+	}
+
+	return plays, nil
+}
+
+// newPlay makes a play of the fields common to the formats, the
+// optional track number and duration in seconds can be empty.
+func newPlay(artist, name, album, number, duration string, playedAt time.Time) (Play, error) {
+	if artist == "" || name == "" {
+		return Play{}, errors.New("the artist and track are required")
+	}
+
+	track := models.Track{
+		Artist:    artist,
+		Name:      name,
+		Album:     album,
+		Timestamp: playedAt,
+	}
+
+	if number != "" {
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return Play{}, errors.New("invalid track number")
+		}
+
+		track.Number = n
+	}
+
+	if duration != "" {
+		seconds, err := strconv.Atoi(duration)
+		if err != nil {
+			return Play{}, errors.New("invalid duration")
+		}
+
+		track.Duration = time.Duration(seconds) * time.Second
+	}
+
+	return Play{Track: track}, nil
+}
+
+func parsePlayedAt(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	if timestamp, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(timestamp, 0), nil
+	}
+
+	t, err := helpers.ParseTime(v)
+	if err == nil && t.IsZero() {
+		return t, helpers.ErrInvalidTimeFormat
+	}
+
+	return t, err
+}
+
+// Dedupe drops the plays of the same track at the same second as one of the existing
+// plays or an earlier play, ignoring case. It returns the rest and how many were dropped.
+func Dedupe(plays []Play, existing []models.Track) ([]Play, int) {
+	seen := make(map[string]struct{}, len(plays)+len(existing))
+	for _, track := range existing {
+		seen[key(track)] = struct{}{}
+	}
+
+	unique := make([]Play, 0, len(plays))
+	for _, play := range plays {
+		k := key(play.Track)
+		if _, ok := seen[k]; ok {
+			continue
+		}
+
+		seen[k] = struct{}{}
+		unique = append(unique, play)
+	}
+
+	return unique, len(plays) - len(unique)
+}
+
+func key(track models.Track) string {
+	return strings.ToLower(track.Artist) + "\x00" + strings.ToLower(track.Name) + "\x00" +
+		strconv.FormatInt(track.Timestamp.Unix(), 10)
+}
@@ -0,0 +1,151 @@
+package formats
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+)
+
+func TestReadWritten(t *testing.T) {
+	written := []Play{plays[0], {Track: models.Track{Artist: "Aphex Twin", Name: "Xtal"}, Skipped: true}}
+	written[1].Track.Timestamp = plays[1].Track.Timestamp
+
+	var buf bytes.Buffer
+	if err := WriteScrobblerLog(&buf, written, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := ReadScrobblerLog(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err = WriteCSV(&buf, written); err != nil {
+		t.Fatal(err)
+	}
+
+	csv, err := ReadCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, read := range [][]Play{log, csv} {
+		if len(read) != len(written) {
+			t.Fatalf("expected %d plays, got %d", len(written), len(read))
+		}
+
+		for i, play := range read {
+			// Durations are written in whole seconds
+			expected := written[i]
+			expected.Track.Duration = expected.Track.Duration.Round(time.Second)
+
+			if !play.Track.Timestamp.Equal(expected.Track.Timestamp) {
+				t.Errorf("expected %v, got %v", expected.Track.Timestamp, play.Track.Timestamp)
+			}
+
+			play.Track.Timestamp = expected.Track.Timestamp
+			if play != expected {
+				t.Errorf("expected %+v, got %+v", expected, play)
+			}
+		}
+	}
+}
+
+func TestReadScrobblerLogLocalTime(t *testing.T) {
+	log := "#AUDIOSCROBBLER/1.1\n#TZ/UNKNOWN\n#CLIENT/Rockbox sansaclipplus $Revision$\n" +
+		"Boards of Canada\tGeogaddi\tAlpha and Omega\t18\t422\tL\t1740860101\t\r\n"
+
+	plays, err := ReadScrobblerLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 20:15:01 on the player's clock
+	expected := time.Date(2025, time.March, 1, 20, 15, 1, 0, time.Local)
+	if len(plays) != 1 || !plays[0].Track.Timestamp.Equal(expected) {
+		t.Fatalf("expected a play at %v, got %+v", expected, plays)
+	}
+
+	if plays[0].Track.Number != 18 || plays[0].Track.Duration != time.Second*422 || plays[0].Track.Album != "Geogaddi" {
+		t.Errorf("unexpected track %+v", plays[0].Track)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		read  func(string) ([]Play, error)
+		input string
+		line  int
+	}{
+		{
+			name:  "scrobbler log rating",
+			read:  readScrobblerLog,
+			input: "#TZ/UTC\nArtist\t\tTrack\t\t100\tX\t1740860101\t\n",
+			line:  2,
+		},
+		{
+			name:  "scrobbler log fields",
+			read:  readScrobblerLog,
+			input: "Artist\tAlbum\tTrack\n",
+			line:  1,
+		},
+		{
+			name:  "csv time",
+			read:  readCSV,
+			input: "artist,track,played_at\nArtist,Track,2025-03-01 20:15\nArtist,Track,yesterday\n",
+			line:  3,
+		},
+		{
+			name:  "csv artist",
+			read:  readCSV,
+			input: "artist,track,played_at\n,Track,1740860101\n",
+			line:  2,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := test.read(test.input)
+
+		var invalid ErrInvalidLine
+		if !errors.As(err, &invalid) || invalid.Line != test.line {
+			t.Errorf("%s: expected an invalid line %d, got %v", test.name, test.line, err)
+		}
+	}
+
+	if _, err := ReadCSV(strings.NewReader("artist,title,time\n")); !errors.Is(err, ErrMissingColumn) {
+		t.Errorf("expected %v, got %v", ErrMissingColumn, err)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	at := time.Date(2025, time.March, 1, 20, 15, 1, 0, time.UTC)
+	track := func(artist, name string, at time.Time) models.Track {
+		return models.Track{Artist: artist, Name: name, Timestamp: at}
+	}
+
+	imported := []Play{
+		{Track: track("Boards of Canada", "Roygbiv", at)},
+		{Track: track("BOARDS OF CANADA", "roygbiv", at)},
+		{Track: track("Boards of Canada", "Roygbiv", at.Add(time.Minute*3))},
+		{Track: track("Aphex Twin", "Xtal", at.Add(time.Minute*6))},
+	}
+
+	unique, duplicates := Dedupe(imported, []models.Track{track("aphex twin", "xtal", at.Add(time.Minute*6))})
+	if duplicates != 2 || len(unique) != 2 || unique[1] != imported[2] {
+		t.Errorf("expected the first and third play, got %+v", unique)
+	}
+}
+
+func readScrobblerLog(input string) ([]Play, error) {
+	return ReadScrobblerLog(strings.NewReader(input))
+}
+
+func readCSV(input string) ([]Play, error) {
+	return ReadCSV(strings.NewReader(input))
+}
@@ -6,17 +6,34 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"math"
 	"net/url"
+	"os"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
 )
 
+// The longest duration that can be represented, with room for the rest of the components
+const maxDBDurationHours = int(math.MaxInt64/int64(time.Hour)) - 1
+
 var (
 	replacer *strings.Replacer
 
 	ErrInvalidDurationFormat = errors.New("invalid duration format")
+	ErrInvalidTimeFormat     = errors.New("invalid time format, use YYYY-MM-DD, YYYY-MM-DD HH:MM or RFC 3339")
+
+	// Accepted by ParseTime, from the most to the least specific
+	timeLayouts = []string{
+		time.RFC3339,
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		time.DateOnly,
+	}
 )
 
 func init() {
@@ -95,6 +112,15 @@ func ParseDBDuration(duration string) (time.Duration, error) {
 		return time.Duration(0), err
 	}
 
+	// minidlna writes H:MM:SS.mmm, anything else would
+	// result in a nonsensical, or overflowing, duration
+	if hours < 0 || hours > maxDBDurationHours ||
+		minutes < 0 || minutes > 59 ||
+		seconds < 0 || seconds > 59 ||
+		miliseconds < 0 || miliseconds > 999 {
+		return time.Duration(0), ErrInvalidDurationFormat
+	}
+
 	result := time.Hour * time.Duration(hours)
 	result += time.Minute * time.Duration(minutes)
 	result += time.Second * time.Duration(seconds)
@@ -106,3 +132,56 @@ func ParseDBDuration(duration string) (time.Duration, error) {
 func ReplaceSpecialChars(in string) string {
 	return replacer.Replace(in)
 }
+
+// CacheDir returns the application cache directory,
+// creating it if it doesn't exist yet.
+func CacheDir() (string, error) {
+	return appDir(constants.XDGCacheDIR, "/var/cache")
+}
+
+// StateDir is where data that should outlive the cache is kept, e.g. the listening history.
+func StateDir() (string, error) {
+	return appDir(constants.XDGStateDir, "/var/lib")
+}
+
+// appDir returns the application's directory in the base directory named by the
+// environment variable, or the fallback if it isn't set. It's created if needed.
+func appDir(env string, fallback string) (string, error) {
+	dir := fallback
+	v, set := os.LookupEnv(env)
+	if set && v != "" && filepath.IsAbs(v) {
+		dir = v
+	}
+
+	dir = filepath.Join(dir, "minidlna-scrobbler")
+	_, err := os.Stat(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+
+		err = os.MkdirAll(dir, 0o744)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
+// ParseTime parses a point in time given on the command line, in local time unless
+// the zone is specified. An empty string results in the zero time.
+func ParseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, v, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, ErrInvalidTimeFormat
+}
@@ -0,0 +1,83 @@
+package helpers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func FuzzParseDBDuration(f *testing.F) {
+	seeds := []string{
+		"0:03:45.123",
+		"1:02:03.004",
+		"0:00:00.000",
+		"12:59:59.999",
+		"",
+		"3:45",
+		"0:03:45",
+		"0:03:45.",
+		"-0:03:45.123",
+		"0:-3:45.123",
+		"0:03:60.000",
+		"0:03:45.1234",
+		"+0:+03:+45.+123",
+		"9999999999999:00:00.000",
+		"0:03:45.123.456",
+		"a:b:c.d",
+	}
+
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, duration string) {
+		d, err := ParseDBDuration(duration)
+		if err != nil {
+			return
+		}
+
+		if d < 0 {
+			t.Fatalf("%q: negative duration %v", duration, d)
+		}
+
+		// Whatever was accepted has to be the duration minidlna would write like that
+		if roundTrip, _ := ParseDBDuration(formatDBDuration(d)); roundTrip != d {
+			t.Fatalf("%q: parsed as %v, which is written as %q", duration, d, formatDBDuration(d))
+		}
+	})
+}
+
+func FuzzParseDBDurationFormatted(f *testing.F) {
+	f.Add(uint32(0), uint8(3), uint8(45), uint16(123))
+	f.Add(uint32(1), uint8(0), uint8(0), uint16(0))
+	f.Add(uint32(100), uint8(59), uint8(59), uint16(999))
+
+	f.Fuzz(func(t *testing.T, hours uint32, minutes uint8, seconds uint8, ms uint16) {
+		minutes, seconds, ms = minutes%60, seconds%60, ms%1000
+		expected := time.Duration(hours)*time.Hour +
+			time.Duration(minutes)*time.Minute +
+			time.Duration(seconds)*time.Second +
+			time.Duration(ms)*time.Millisecond
+
+		duration := fmt.Sprintf("%d:%02d:%02d.%03d", hours, minutes, seconds, ms)
+		d, err := ParseDBDuration(duration)
+		if err != nil {
+			t.Fatalf("%q: %v", duration, err)
+		}
+
+		if d != expected {
+			t.Fatalf("%q: expected %v, got %v", duration, expected, d)
+		}
+	})
+}
+
+// formatDBDuration writes the duration the way minidlna stores it
+func formatDBDuration(d time.Duration) string {
+	return fmt.Sprintf(
+		"%d:%02d:%02d.%03d",
+		int64(d/time.Hour),
+		int64(d/time.Minute%60),
+		int64(d/time.Second%60),
+		int64(d/time.Millisecond%1000),
+	)
+}
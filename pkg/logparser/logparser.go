@@ -1,96 +1,570 @@
 package logparser
 
 import (
-	"bufio"
+	"net/netip"
+	"strconv"
 	"strings"
+	"time"
+)
+
+// TimestampLayout is the format of the timestamp minidlna prefixes log lines with
+const TimestampLayout = "2006/01/02 15:04:05"
+
+const (
+	KindOther Kind = iota
+	KindServing
+	KindHTTPConnection
+	KindClientFound
+	KindAlbumArt
+	KindScanner
+	KindInotify
+	KindRange
 )
 
 const (
-	stateSource = iota
-	stateLineNumber
-	stateLogLevel
-	stateMessage
-	stateMessageID
-	stateFilepath
+	LevelOff      Level = "off"
+	LevelFatal    Level = "fatal"
+	LevelError    Level = "error"
+	LevelWarn     Level = "warn"
+	LevelInfo     Level = "info"
+	LevelDebug    Level = "debug"
+	LevelMaxDebug Level = "maxdebug"
 )
 
+const (
+	prefixServing     = "Serving DetailID: "
+	prefixConnection  = "HTTP connection from "
+	prefixClientFound = "Client found in cache. ["
+	prefixAlbumArt    = "Serving album art ID: "
+	prefixFoundArt    = "Found album art in "
+	prefixScanning    = "Scanning "
+	prefixWatch       = "Added watch to "
+	prefixFile        = "The file "
+	prefixDirectory   = "The directory "
+	prefixRange       = "Range Start-End: "
+
+	suffixScanFinished = " files)!"
+	infixScanFinished  = " finished ("
+	infixWatch         = " ["
+	infixAction        = " was "
+)
+
+// Changes of watched files and directories
+var inotifyActions = []string{
+	"created",
+	"changed",
+	"deleted",
+	"moved here",
+	"moved away",
+}
+
 type (
-	Data struct {
+	// Kind of a message, KindOther for those that aren't recognized.
+	Kind int
+
+	// Level is the severity minidlna logged the line with.
+	Level string
+
+	// Line is a single line of the minidlna log.
+	// Its strings share memory with the parsed line.
+	Line struct {
+		// When the line was logged, zero if minidlna
+		// was configured to log without timestamps
+		Timestamp  time.Time
 		SourceFile string
-		LineNumber string
-		LogLevel   string
+		LineNumber int
+		Level      Level
 		Message    string
-		MessageID  string
-		Filepath   string
+		Kind       Kind
+
+		// Details of the recognized message, only the one
+		// matching the kind is set. They're stored by value,
+		// so parsing a line doesn't need to allocate.
+		serving    Serving
+		connection HTTPConnection
+		client     ClientFound
+		albumArt   AlbumArt
+		scanner    Scanner
+		inotify    Inotify
+		rng        Range
+	}
+
+	// Serving is logged when a media file is streamed to a client.
+	Serving struct {
+		DetailID int
+		Path     string
+	}
+
+	// HTTPConnection is logged for every request a client makes.
+	HTTPConnection struct {
+		Addr netip.AddrPort
+	}
+
+	// ClientFound is logged when a request comes from a known client.
+	ClientFound struct {
+		Details string
+	}
+
+	// AlbumArt is logged when album art is found by the
+	// scanner, or served to a client. ID is 0 for the former.
+	AlbumArt struct {
+		ID   int
+		Path string
+	}
+
+	// Scanner is logged when the scan of a media directory starts and finishes.
+	Scanner struct {
+		Path     string
+		Finished bool
+		Files    int
+	}
+
+	// Inotify is logged when a watched file or directory changes,
+	// and when a directory starts being watched.
+	Inotify struct {
+		Path string
+		Dir  bool
+		// created, changed, deleted, moved here, moved away or watched
+		Action string
+	}
+
+	// Range is logged at the debug level for requests of part
+	// of a file, before the file is served. End is -1 if the
+	// client asked for everything after the start.
+	Range struct {
+		Start int64
+		End   int64
+	}
+
+	ErrMalformedLine struct {
+		Line   string
+		Reason string
 	}
 )
 
-func ParseLine(line string) (Data, error) {
-	scanner := bufio.NewScanner(strings.NewReader(line))
-	scanner.Split(bufio.ScanRunes)
-
-	// This is the end delimeter
-	// that needs to be handled like
-	// a special case, if it appears in the filepath
-	special := "]"
-	specialCount := 0
-	totalSpecialCount := strings.Count(line, special)
-	state := stateSource
-	data := Data{}
-	for scanner.Scan() {
-		c := scanner.Text()
-		switch state {
-		case stateSource:
-			if c != ":" {
-				data.SourceFile += c
-				continue
-			}
+func (e ErrMalformedLine) Error() string {
+	return "malformed log line (" + e.Reason + "): " + e.Line
+}
 
-			state = stateLineNumber
-		case stateLineNumber:
-			if c != ":" {
-				data.LineNumber += c
-				continue
-			}
+func (k Kind) String() string {
+	switch k {
+	case KindServing:
+		return "serving"
+	case KindHTTPConnection:
+		return "http connection"
+	case KindClientFound:
+		return "client found"
+	case KindAlbumArt:
+		return "album art"
+	case KindScanner:
+		return "scanner"
+	case KindInotify:
+		return "inotify"
+	case KindRange:
+		return "range"
+	default:
+		return "other"
+	}
+}
 
-			state = stateLogLevel
-		case stateLogLevel:
-			if c != ":" {
-				data.LogLevel += strings.TrimSpace(c)
-				continue
-			}
+func (l Line) Serving() (Serving, bool) {
+	return l.serving, l.Kind == KindServing
+}
 
-			state = stateMessage
-		case stateMessage:
-			if c != ":" {
-				data.Message += strings.TrimSpace(c)
-				continue
-			}
+func (l Line) HTTPConnection() (HTTPConnection, bool) {
+	return l.connection, l.Kind == KindHTTPConnection
+}
+
+func (l Line) ClientFound() (ClientFound, bool) {
+	return l.client, l.Kind == KindClientFound
+}
+
+func (l Line) AlbumArt() (AlbumArt, bool) {
+	return l.albumArt, l.Kind == KindAlbumArt
+}
+
+func (l Line) Scanner() (Scanner, bool) {
+	return l.scanner, l.Kind == KindScanner
+}
 
-			state = stateMessageID
-		case stateMessageID:
-			if c != "[" {
-				data.MessageID += strings.TrimSpace(c)
-				continue
+func (l Line) Inotify() (Inotify, bool) {
+	return l.inotify, l.Kind == KindInotify
+}
+
+func (l Line) Range() (Range, bool) {
+	return l.rng, l.Kind == KindRange
+}
+
+// ParseLine parses a line of the minidlna log, and the message if it's a recognized one:
+//
+//	[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 1234 [/music/a.flac]
+//
+// The timestamp is missing from the logs of older minidlna versions.
+// Lines that don't follow the format, or recognized messages with invalid details, are errors.
+// The line is only indexed into, nothing is allocated unless it's malformed.
+func ParseLine(line string) (Line, error) {
+	var (
+		parsed Line
+		ts     string
+	)
+
+	rest := line
+	if len(rest) > 0 && rest[0] == '[' {
+		const prefixLen = len(TimestampLayout) + 3
+		if len(rest) < prefixLen || !isTimestamp(rest[1:prefixLen-2]) || rest[prefixLen-2:prefixLen] != "] " {
+			return Line{}, malformed(line, "unknown format")
+		}
+
+		ts = rest[1 : prefixLen-2]
+		rest = rest[prefixLen:]
+	}
+
+	// Split on the first three colons, none of the fields before the message can contain one
+	source, rest, ok := strings.Cut(rest, ":")
+	if !ok || !isSourceFile(source) {
+		return Line{}, malformed(line, "unknown format")
+	}
+
+	lineNumber, rest, ok := strings.Cut(rest, ": ")
+	if !ok || !isDigits(lineNumber) {
+		return Line{}, malformed(line, "unknown format")
+	}
+
+	level, msg, ok := strings.Cut(rest, ": ")
+	if !ok || !isLowercase(level) || strings.IndexByte(msg, '\n') >= 0 {
+		return Line{}, malformed(line, "unknown format")
+	}
+
+	if ts != "" {
+		t, ok := parseTimestamp(ts)
+		if !ok {
+			return Line{}, malformed(line, "invalid timestamp")
+		}
+
+		parsed.Timestamp = t
+	}
+
+	n, err := strconv.Atoi(lineNumber)
+	if err != nil {
+		return Line{}, malformed(line, "invalid line number")
+	}
+
+	parsed.SourceFile = source
+	parsed.LineNumber = n
+	parsed.Level = Level(level)
+	parsed.Message = msg
+
+	if !parsed.Level.valid() {
+		return Line{}, malformed(line, "unknown level")
+	}
+
+	if reason := parsed.parseMessage(); reason != "" {
+		return Line{}, malformed(line, reason)
+	}
+
+	return parsed, nil
+}
+
+func (l Level) valid() bool {
+	switch l {
+	case LevelOff, LevelFatal, LevelError, LevelWarn, LevelInfo, LevelDebug, LevelMaxDebug:
+		return true
+	}
+
+	return false
+}
+
+// parseMessage sets the kind and details of a recognized message.
+// The reason is set if the message looks like a recognized one, but isn't valid.
+func (l *Line) parseMessage() string {
+	msg := l.Message
+	switch {
+	case strings.HasPrefix(msg, prefixServing):
+		id, path, ok := idAndPath(msg[len(prefixServing):])
+		if !ok {
+			return "invalid serving message"
+		}
+
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return "invalid detail id"
+		}
+
+		l.Kind = KindServing
+		l.serving = Serving{DetailID: n, Path: path}
+	case strings.HasPrefix(msg, "Serving DetailID:"):
+		return "invalid serving message"
+	case strings.HasPrefix(msg, prefixConnection):
+		rest := msg[len(prefixConnection):]
+		i := strings.LastIndexByte(rest, ':')
+		if i < 1 || !isDigits(rest[i+1:]) {
+			return "invalid connection message"
+		}
+
+		addr, err := netip.ParseAddr(strings.Trim(rest[:i], "[]"))
+		if err != nil {
+			return "invalid client address"
+		}
+
+		port, err := strconv.ParseUint(rest[i+1:], 10, 16)
+		if err != nil {
+			return "invalid client port"
+		}
+
+		l.Kind = KindHTTPConnection
+		l.connection = HTTPConnection{Addr: netip.AddrPortFrom(addr, uint16(port))}
+	case strings.HasPrefix(msg, "Client found"):
+		if !strings.HasPrefix(msg, prefixClientFound) || !strings.HasSuffix(msg[len(prefixClientFound):], "]") {
+			return "invalid client message"
+		}
+
+		l.Kind = KindClientFound
+		l.client = ClientFound{Details: msg[len(prefixClientFound) : len(msg)-1]}
+	case strings.HasPrefix(msg, prefixAlbumArt):
+		id, path, ok := idAndPath(msg[len(prefixAlbumArt):])
+		if !ok {
+			return "invalid album art message"
+		}
+
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return "invalid album art id"
+		}
+
+		l.Kind = KindAlbumArt
+		l.albumArt = AlbumArt{ID: n, Path: path}
+	case strings.HasPrefix(msg, "Serving album art ID:"):
+		return "invalid album art message"
+	case strings.HasPrefix(msg, prefixFoundArt):
+		path := msg[len(prefixFoundArt):]
+		if path == "" {
+			return "invalid album art message"
+		}
+
+		l.Kind = KindAlbumArt
+		l.albumArt = AlbumArt{Path: path}
+	case strings.HasPrefix(msg, prefixScanning):
+		rest := msg[len(prefixScanning):]
+		if path, files, ok := trailingNumber(rest, infixScanFinished, suffixScanFinished); ok {
+			n, err := strconv.Atoi(files)
+			if err != nil {
+				return "invalid file count"
 			}
 
-			state = stateFilepath
-		case stateFilepath:
-			if c == special {
-				// handle the case where this is not the last occurrence
-				specialCount++
-				if specialCount < totalSpecialCount {
-					data.Filepath += c
-				}
-			} else {
-				data.Filepath += c
+			l.Kind = KindScanner
+			l.scanner = Scanner{Path: path, Finished: true, Files: n}
+
+			return ""
+		}
+
+		if rest == "" {
+			return "invalid scanner message"
+		}
+
+		l.Kind = KindScanner
+		l.scanner = Scanner{Path: rest}
+	case strings.HasPrefix(msg, prefixWatch):
+		path, _, ok := trailingNumber(msg[len(prefixWatch):], infixWatch, "]")
+		if !ok {
+			return "invalid watch message"
+		}
+
+		l.Kind = KindInotify
+		l.inotify = Inotify{Path: path, Dir: true, Action: "watched"}
+	case strings.HasPrefix(msg, prefixRange):
+		start, end, ok := strings.Cut(msg[len(prefixRange):], " - ")
+		if !ok || !isDigits(start) || (end != "-1" && !isDigits(end)) {
+			return "invalid range message"
+		}
+
+		s, err := strconv.ParseInt(start, 10, 64)
+		if err != nil {
+			return "invalid range start"
+		}
+
+		e, err := strconv.ParseInt(end, 10, 64)
+		if err != nil {
+			return "invalid range end"
+		}
+
+		l.Kind = KindRange
+		l.rng = Range{Start: s, End: e}
+	case strings.HasPrefix(msg, "Range Start-End:"):
+		return "invalid range message"
+	case strings.HasPrefix(msg, prefixFile):
+		l.parseInotify(msg[len(prefixFile):], false)
+	case strings.HasPrefix(msg, prefixDirectory):
+		l.parseInotify(msg[len(prefixDirectory):], true)
+	}
+
+	return ""
+}
+
+// parseInotify recognizes "PATH was ACTION.", other messages start the same way.
+func (l *Line) parseInotify(rest string, dir bool) {
+	rest, ok := strings.CutSuffix(rest, ".")
+	if !ok {
+		return
+	}
+
+	for _, action := range inotifyActions {
+		path, ok := strings.CutSuffix(rest, action)
+		if !ok {
+			continue
+		}
+
+		path, ok = strings.CutSuffix(path, infixAction)
+		if !ok || path == "" {
+			return
+		}
+
+		l.Kind = KindInotify
+		l.inotify = Inotify{Path: path, Dir: dir, Action: action}
+
+		return
+	}
+}
+
+// idAndPath splits "ID [PATH]", the path can contain any character.
+func idAndPath(s string) (string, string, bool) {
+	id, path, ok := strings.Cut(s, " [")
+	if !ok || !isDigits(id) {
+		return "", "", false
+	}
+
+	path, ok = strings.CutSuffix(path, "]")
+
+	return id, path, ok
+}
+
+// trailingNumber splits "PREFIX<infix>NUMBER<suffix>", the prefix can't be empty.
+func trailingNumber(s string, infix string, suffix string) (string, string, bool) {
+	s, ok := strings.CutSuffix(s, suffix)
+	if !ok {
+		return "", "", false
+	}
+
+	i := len(s)
+	for i > 0 && isDigit(s[i-1]) {
+		i--
+	}
+
+	number := s[i:]
+	prefix, ok := strings.CutSuffix(s[:i], infix)
+	if !ok || number == "" || prefix == "" {
+		return "", "", false
+	}
+
+	return prefix, number, true
+}
+
+// parseTimestamp parses a timestamp that's known to be in the right
+// format, the same way time.ParseInLocation does, without allocating.
+func parseTimestamp(s string) (time.Time, bool) {
+	year := atoi(s[0:4])
+	month := time.Month(atoi(s[5:7]))
+	day := atoi(s[8:10])
+	hour := atoi(s[11:13])
+	minute := atoi(s[14:16])
+	second := atoi(s[17:19])
+
+	if month < time.January || month > time.December ||
+		day < 1 || day > daysIn(year, month) ||
+		hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, false
+	}
+
+	return time.Date(year, month, day, hour, minute, second, 0, time.Local), true
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// isTimestamp reports whether s is shaped like TimestampLayout.
+func isTimestamp(s string) bool {
+	if len(s) != len(TimestampLayout) {
+		return false
+	}
+
+	for i := range len(s) {
+		switch TimestampLayout[i] {
+		case '/', ' ', ':':
+			if s[i] != TimestampLayout[i] {
+				return false
+			}
+		default:
+			if !isDigit(s[i]) {
+				return false
 			}
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return Data{}, err
+	return true
+}
+
+// isSourceFile reports whether s is the name of a C source or header file.
+func isSourceFile(s string) bool {
+	if len(s) < 3 || s[len(s)-2] != '.' || (s[len(s)-1] != 'c' && s[len(s)-1] != 'h') {
+		return false
 	}
 
-	return data, nil
+	for i := range len(s) {
+		c := s[i]
+		if !isDigit(c) && !isLetter(c) && c != '_' && c != '.' && c != '-' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := range len(s) {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isLowercase(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := range len(s) {
+		if s[i] < 'a' || s[i] > 'z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// atoi parses digits that are known to be valid.
+func atoi(s string) int {
+	n := 0
+	for i := range len(s) {
+		n = n*10 + int(s[i]-'0')
+	}
+
+	return n
+}
+
+func malformed(line string, reason string) error {
+	return ErrMalformedLine{Line: line, Reason: reason}
 }
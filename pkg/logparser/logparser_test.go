@@ -0,0 +1,237 @@
+package logparser
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	ts := time.Date(2025, time.January, 31, 18, 0, 1, 0, time.Local)
+	tests := []struct {
+		line     string
+		expected result
+	}{
+		{
+			line: "[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 1234 [/music/Artist [Live]/01 - Track.flac]",
+			expected: result{
+				Timestamp:  ts,
+				SourceFile: "upnphttp.c",
+				LineNumber: 1923,
+				Level:      LevelInfo,
+				Message:    "Serving DetailID: 1234 [/music/Artist [Live]/01 - Track.flac]",
+				Kind:       KindServing,
+				Event:      Serving{DetailID: 1234, Path: "/music/Artist [Live]/01 - Track.flac"},
+			},
+		},
+		{
+			// Older versions don't log timestamps
+			line: "upnphttp.c:1923: info: Serving DetailID: 7 [/music/a.mp3]",
+			expected: result{
+				SourceFile: "upnphttp.c",
+				LineNumber: 1923,
+				Level:      LevelInfo,
+				Message:    "Serving DetailID: 7 [/music/a.mp3]",
+				Kind:       KindServing,
+				Event:      Serving{DetailID: 7, Path: "/music/a.mp3"},
+			},
+		},
+		{
+			line: "[2025/01/31 18:00:01] minidlna.c:1231: debug: HTTP connection from 192.168.1.10:53122",
+			expected: result{
+				Timestamp:  ts,
+				SourceFile: "minidlna.c",
+				LineNumber: 1231,
+				Level:      LevelDebug,
+				Message:    "HTTP connection from 192.168.1.10:53122",
+				Kind:       KindHTTPConnection,
+				Event:      HTTPConnection{Addr: netip.MustParseAddrPort("192.168.1.10:53122")},
+			},
+		},
+		{
+			line: "[2025/01/31 18:00:01] upnphttp.c:280: debug: Client found in cache. [Sonos type 21/entry 0]",
+			expected: result{
+				Timestamp:  ts,
+				SourceFile: "upnphttp.c",
+				LineNumber: 280,
+				Level:      LevelDebug,
+				Message:    "Client found in cache. [Sonos type 21/entry 0]",
+				Kind:       KindClientFound,
+				Event:      ClientFound{Details: "Sonos type 21/entry 0"},
+			},
+		},
+		{
+			line: "[2025/01/31 18:00:01] upnphttp.c:1640: info: Serving album art ID: 12 [/music/cover.jpg]",
+			expected: result{
+				Timestamp:  ts,
+				SourceFile: "upnphttp.c",
+				LineNumber: 1640,
+				Level:      LevelInfo,
+				Message:    "Serving album art ID: 12 [/music/cover.jpg]",
+				Kind:       KindAlbumArt,
+				Event:      AlbumArt{ID: 12, Path: "/music/cover.jpg"},
+			},
+		},
+		{
+			line: "[2025/01/31 18:00:01] scanner.c:731: info: Scanning /music",
+			expected: result{
+				Timestamp:  ts,
+				SourceFile: "scanner.c",
+				LineNumber: 731,
+				Level:      LevelInfo,
+				Message:    "Scanning /music",
+				Kind:       KindScanner,
+				Event:      Scanner{Path: "/music"},
+			},
+		},
+		{
+			line: "[2025/01/31 18:00:01] scanner.c:822: info: Scanning /music finished (1234 files)!",
+			expected: result{
+				Timestamp:  ts,
+				SourceFile: "scanner.c",
+				LineNumber: 822,
+				Level:      LevelInfo,
+				Message:    "Scanning /music finished (1234 files)!",
+				Kind:       KindScanner,
+				Event:      Scanner{Path: "/music", Finished: true, Files: 1234},
+			},
+		},
+		{
+			line: "[2025/01/31 18:00:01] inotify.c:701: debug: The file /music/new.flac was created.",
+			expected: result{
+				Timestamp:  ts,
+				SourceFile: "inotify.c",
+				LineNumber: 701,
+				Level:      LevelDebug,
+				Message:    "The file /music/new.flac was created.",
+				Kind:       KindInotify,
+				Event:      Inotify{Path: "/music/new.flac", Action: "created"},
+			},
+		},
+		{
+			line: "[2025/01/31 18:00:01] inotify.c:146: debug: Added watch to /music/album [42]",
+			expected: result{
+				Timestamp:  ts,
+				SourceFile: "inotify.c",
+				LineNumber: 146,
+				Level:      LevelDebug,
+				Message:    "Added watch to /music/album [42]",
+				Kind:       KindInotify,
+				Event:      Inotify{Path: "/music/album", Dir: true, Action: "watched"},
+			},
+		},
+		{
+			line: "[2025/01/31 18:00:01] upnphttp.c:1067: debug: Range Start-End: 1048576 - -1",
+			expected: result{
+				Timestamp:  ts,
+				SourceFile: "upnphttp.c",
+				LineNumber: 1067,
+				Level:      LevelDebug,
+				Message:    "Range Start-End: 1048576 - -1",
+				Kind:       KindRange,
+				Event:      Range{Start: 1048576, End: -1},
+			},
+		},
+		{
+			line: "[2025/01/31 18:00:01] minidlna.c:1126: warn: starting MiniDLNA version 1.3.3.",
+			expected: result{
+				Timestamp:  ts,
+				SourceFile: "minidlna.c",
+				LineNumber: 1126,
+				Level:      LevelWarn,
+				Message:    "starting MiniDLNA version 1.3.3.",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		line, err := ParseLine(test.line)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+
+		parsed := resultOf(line)
+		if !parsed.Timestamp.Equal(test.expected.Timestamp) {
+			t.Errorf("%q: expected timestamp %v, got %v", test.line, test.expected.Timestamp, parsed.Timestamp)
+		}
+
+		parsed.Timestamp = test.expected.Timestamp
+		if parsed != test.expected {
+			t.Errorf("%q: expected %+v, got %+v", test.line, test.expected, parsed)
+		}
+	}
+}
+
+func TestParseLineAllocations(t *testing.T) {
+	for _, line := range benchmarkLines {
+		allocs := testing.AllocsPerRun(100, func() {
+			if _, err := ParseLine(line); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		if allocs > 0 {
+			t.Errorf("%q: expected no allocations, got %v", line, allocs)
+		}
+	}
+}
+
+func TestParseLineMalformed(t *testing.T) {
+	lines := []string{
+		"",
+		"garbage",
+		"[2025/01/31 18:00:01]",
+		"[2025/13/45 18:00:01] upnphttp.c:1923: info: Serving DetailID: 1 [/a.mp3]",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: loud: Serving DetailID: 1 [/a.mp3]",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: abc [/a.mp3]",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 1 /a.mp3",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 99999999999999999999 [/a.mp3]",
+		"[2025/01/31 18:00:01] minidlna.c:1231: debug: HTTP connection from nowhere:80",
+		"[2025/01/31 18:00:01] minidlna.c:1231: debug: HTTP connection from 192.168.1.10:99999",
+		"[2025/01/31 18:00:01] upnphttp.c:1067: debug: Range Start-End: 0 to -1",
+		"[2025/01/31 18:00:01] upnphttp.c:1067: debug: Range Start-End: 0 - 99999999999999999999",
+	}
+
+	for _, line := range lines {
+		_, err := ParseLine(line)
+
+		var malformed ErrMalformedLine
+		if !errors.As(err, &malformed) {
+			t.Errorf("%q: expected a malformed line error, got %v", line, err)
+		}
+	}
+}
+
+func FuzzParseLineServing(f *testing.F) {
+	f.Add(1234, "/music/Artist/Album/01 - Track.flac")
+	f.Add(1, "/music/Artist [Live]/01: Intro].flac")
+	f.Add(7, "/music/坂本龍一/戦場のメリークリスマス.mp3")
+	f.Add(0, "")
+	f.Add(42, "]")
+
+	f.Fuzz(func(t *testing.T, id int, path string) {
+		if id < 0 || strings.ContainsRune(path, '\n') {
+			// minidlna can't log those
+			t.Skip()
+		}
+
+		line := fmt.Sprintf("[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: %d [%s]", id, path)
+		parsed, err := ParseLine(line)
+		if err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+
+		serving, ok := parsed.Serving()
+		if !ok {
+			t.Fatalf("%q: expected a serving line, got %v", line, parsed.Kind)
+		}
+
+		if serving.DetailID != id || serving.Path != path {
+			t.Fatalf("%q: expected %d %q, got %d %q", line, id, path, serving.DetailID, serving.Path)
+		}
+	})
+}
@@ -0,0 +1,381 @@
+package logparser
+
+import (
+	"errors"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The regular expression based parser ParseLine replaced. It's
+// kept as the reference the hand written one is checked against.
+var (
+	refLineRegexp = regexp.MustCompile(
+		`^(?:\[(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})\] )?([\w.-]+\.[ch]):(\d+): ([a-z]+): (.*)$`,
+	)
+
+	refServingRegexp     = regexp.MustCompile(`^Serving DetailID: (\d+) \[(.*)\]$`)
+	refConnectionRegexp  = regexp.MustCompile(`^HTTP connection from (.+):(\d+)$`)
+	refClientFoundRegexp = regexp.MustCompile(`^Client found in cache\. \[(.*)\]$`)
+	refAlbumArtRegexp    = regexp.MustCompile(`^Serving album art ID: (\d+) \[(.*)\]$`)
+	refFoundArtRegexp    = regexp.MustCompile(`^Found album art in (.+)$`)
+	refScanFinishRegexp  = regexp.MustCompile(`^Scanning (.+) finished \((\d+) files\)!$`)
+	refScanRegexp        = regexp.MustCompile(`^Scanning (.+)$`)
+	refWatchRegexp       = regexp.MustCompile(`^Added watch to (.+) \[(\d+)\]$`)
+	refRangeRegexp       = regexp.MustCompile(`^Range Start-End: (\d+) - (-1|\d+)$`)
+	refInotifyRegexp     = regexp.MustCompile(
+		`^The (file|directory) (.+) was (created|changed|deleted|moved here|moved away)\.$`,
+	)
+
+	refLevels = map[Level]struct{}{
+		LevelOff:      {},
+		LevelFatal:    {},
+		LevelError:    {},
+		LevelWarn:     {},
+		LevelInfo:     {},
+		LevelDebug:    {},
+		LevelMaxDebug: {},
+	}
+
+	// Real lines of every kind, and a few with paths that are hard to parse
+	benchmarkLines = []string{
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 1234 [/music/Artist/Album/01 - Track.flac]",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 1234 [/music/Artist [Live]/01: Intro].flac]",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 99 [/music/Sigur Rós/( )/01 - Untitled #1.flac]",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 7 [/music/坂本龍一/戦場のメリークリスマス.mp3]",
+		"upnphttp.c:1923: info: Serving DetailID: 7 [/music/a.mp3]",
+		"[2025/01/31 18:00:01] minidlna.c:1231: debug: HTTP connection from 192.168.1.10:53122",
+		"[2025/01/31 18:00:01] minidlna.c:1231: debug: HTTP connection from [fe80::1]:53122",
+		"[2025/01/31 18:00:01] upnphttp.c:280: debug: Client found in cache. [Sonos type 21/entry 0]",
+		"[2025/01/31 18:00:01] upnphttp.c:1640: info: Serving album art ID: 12 [/music/cover.jpg]",
+		"[2025/01/31 18:00:01] albumart.c:350: debug: Found album art in /music/Album/folder.jpg",
+		"[2025/01/31 18:00:01] scanner.c:731: info: Scanning /music",
+		"[2025/01/31 18:00:01] scanner.c:822: info: Scanning /music finished (1234 files)!",
+		"[2025/01/31 18:00:01] inotify.c:701: debug: The directory /music/New: Album was created.",
+		"[2025/01/31 18:00:01] inotify.c:146: debug: Added watch to /music/album [42]",
+		"[2025/01/31 18:00:01] upnphttp.c:1067: debug: Range Start-End: 1048576 - -1",
+		"[2025/01/31 18:00:01] minidlna.c:1126: warn: starting MiniDLNA version 1.3.3.",
+	}
+)
+
+type (
+	// result is a comparable view of a parsed line
+	result struct {
+		Timestamp  time.Time
+		SourceFile string
+		LineNumber int
+		Level      Level
+		Message    string
+		Kind       Kind
+		// The details of a recognized message
+		Event any
+	}
+)
+
+func resultOf(l Line) result {
+	r := result{
+		Timestamp:  l.Timestamp,
+		SourceFile: l.SourceFile,
+		LineNumber: l.LineNumber,
+		Level:      l.Level,
+		Message:    l.Message,
+		Kind:       l.Kind,
+	}
+
+	switch l.Kind {
+	case KindServing:
+		r.Event, _ = l.Serving()
+	case KindHTTPConnection:
+		r.Event, _ = l.HTTPConnection()
+	case KindClientFound:
+		r.Event, _ = l.ClientFound()
+	case KindAlbumArt:
+		r.Event, _ = l.AlbumArt()
+	case KindScanner:
+		r.Event, _ = l.Scanner()
+	case KindInotify:
+		r.Event, _ = l.Inotify()
+	case KindRange:
+		r.Event, _ = l.Range()
+	}
+
+	return r
+}
+
+func referenceParseLine(line string) (result, error) {
+	m := refLineRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return result{}, ErrMalformedLine{Line: line, Reason: "unknown format"}
+	}
+
+	parsed := result{
+		SourceFile: m[2],
+		Level:      Level(m[4]),
+		Message:    m[5],
+	}
+
+	if m[1] != "" {
+		ts, err := time.ParseInLocation(TimestampLayout, m[1], time.Local)
+		if err != nil {
+			return result{}, ErrMalformedLine{Line: line, Reason: "invalid timestamp"}
+		}
+
+		parsed.Timestamp = ts
+	}
+
+	lineNumber, err := strconv.Atoi(m[3])
+	if err != nil {
+		return result{}, ErrMalformedLine{Line: line, Reason: "invalid line number"}
+	}
+
+	parsed.LineNumber = lineNumber
+
+	if _, ok := refLevels[parsed.Level]; !ok {
+		return result{}, ErrMalformedLine{Line: line, Reason: "unknown level"}
+	}
+
+	event, reason := referenceParseMessage(parsed.Message)
+	if reason != "" {
+		return result{}, ErrMalformedLine{Line: line, Reason: reason}
+	}
+
+	if event != nil {
+		parsed.Event = event
+		parsed.Kind = referenceKind(event)
+	}
+
+	return parsed, nil
+}
+
+func referenceKind(event any) Kind {
+	switch event.(type) {
+	case Serving:
+		return KindServing
+	case HTTPConnection:
+		return KindHTTPConnection
+	case ClientFound:
+		return KindClientFound
+	case AlbumArt:
+		return KindAlbumArt
+	case Scanner:
+		return KindScanner
+	case Inotify:
+		return KindInotify
+	case Range:
+		return KindRange
+	}
+
+	return KindOther
+}
+
+func referenceParseMessage(msg string) (any, string) {
+	switch {
+	case strings.HasPrefix(msg, "Serving DetailID:"):
+		m := refServingRegexp.FindStringSubmatch(msg)
+		if m == nil {
+			return nil, "invalid serving message"
+		}
+
+		id, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, "invalid detail id"
+		}
+
+		return Serving{DetailID: id, Path: m[2]}, ""
+	case strings.HasPrefix(msg, "HTTP connection from "):
+		m := refConnectionRegexp.FindStringSubmatch(msg)
+		if m == nil {
+			return nil, "invalid connection message"
+		}
+
+		addr, err := netip.ParseAddr(strings.Trim(m[1], "[]"))
+		if err != nil {
+			return nil, "invalid client address"
+		}
+
+		port, err := strconv.ParseUint(m[2], 10, 16)
+		if err != nil {
+			return nil, "invalid client port"
+		}
+
+		return HTTPConnection{Addr: netip.AddrPortFrom(addr, uint16(port))}, ""
+	case strings.HasPrefix(msg, "Client found"):
+		m := refClientFoundRegexp.FindStringSubmatch(msg)
+		if m == nil {
+			return nil, "invalid client message"
+		}
+
+		return ClientFound{Details: m[1]}, ""
+	case strings.HasPrefix(msg, "Serving album art ID:"):
+		m := refAlbumArtRegexp.FindStringSubmatch(msg)
+		if m == nil {
+			return nil, "invalid album art message"
+		}
+
+		id, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, "invalid album art id"
+		}
+
+		return AlbumArt{ID: id, Path: m[2]}, ""
+	case strings.HasPrefix(msg, "Found album art in "):
+		m := refFoundArtRegexp.FindStringSubmatch(msg)
+		if m == nil {
+			return nil, "invalid album art message"
+		}
+
+		return AlbumArt{Path: m[1]}, ""
+	case strings.HasPrefix(msg, "Scanning "):
+		if m := refScanFinishRegexp.FindStringSubmatch(msg); m != nil {
+			files, err := strconv.Atoi(m[2])
+			if err != nil {
+				return nil, "invalid file count"
+			}
+
+			return Scanner{Path: m[1], Finished: true, Files: files}, ""
+		}
+
+		m := refScanRegexp.FindStringSubmatch(msg)
+		if m == nil {
+			return nil, "invalid scanner message"
+		}
+
+		return Scanner{Path: m[1]}, ""
+	case strings.HasPrefix(msg, "Added watch to "):
+		m := refWatchRegexp.FindStringSubmatch(msg)
+		if m == nil {
+			return nil, "invalid watch message"
+		}
+
+		return Inotify{Path: m[1], Dir: true, Action: "watched"}, ""
+	case strings.HasPrefix(msg, "Range Start-End:"):
+		m := refRangeRegexp.FindStringSubmatch(msg)
+		if m == nil {
+			return nil, "invalid range message"
+		}
+
+		start, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, "invalid range start"
+		}
+
+		end, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			return nil, "invalid range end"
+		}
+
+		return Range{Start: start, End: end}, ""
+	case strings.HasPrefix(msg, "The file ") || strings.HasPrefix(msg, "The directory "):
+		if m := refInotifyRegexp.FindStringSubmatch(msg); m != nil {
+			return Inotify{Path: m[2], Dir: m[1] == "directory", Action: m[3]}, ""
+		}
+	}
+
+	return nil, ""
+}
+
+// checkAgainstReference fails the test if ParseLine and the reference disagree on the line.
+func checkAgainstReference(t *testing.T, line string) {
+	t.Helper()
+
+	parsed, err := ParseLine(line)
+	expected, expectedErr := referenceParseLine(line)
+
+	var malformed, expectedMalformed ErrMalformedLine
+	if expectedErr != nil {
+		if !errors.As(err, &malformed) || !errors.As(expectedErr, &expectedMalformed) || malformed != expectedMalformed {
+			t.Fatalf("%q: expected error %v, got %v", line, expectedErr, err)
+		}
+
+		return
+	}
+
+	if err != nil {
+		t.Fatalf("%q: unexpected error %v", line, err)
+	}
+
+	actual := resultOf(parsed)
+	if !actual.Timestamp.Equal(expected.Timestamp) {
+		t.Fatalf("%q: expected timestamp %v, got %v", line, expected.Timestamp, actual.Timestamp)
+	}
+
+	actual.Timestamp = expected.Timestamp
+	if actual != expected {
+		t.Fatalf("%q: expected %+v, got %+v", line, expected, actual)
+	}
+}
+
+func TestParseLineMatchesReference(t *testing.T) {
+	for _, line := range benchmarkLines {
+		checkAgainstReference(t, line)
+	}
+}
+
+func FuzzParseLine(f *testing.F) {
+	for _, line := range benchmarkLines {
+		f.Add(line)
+	}
+
+	// Lines that almost follow the format
+	seeds := []string{
+		"",
+		"[",
+		"[2025/01/31 18:00:01] ",
+		"[2025/02/29 18:00:01] upnphttp.c:1923: info: Serving DetailID: 1 [/a.mp3]",
+		"[2024/02/29 24:00:01] upnphttp.c:1923: info: Serving DetailID: 1 [/a.mp3]",
+		"[2025/01/31 18:00:01]  upnphttp.c:1923: info: Serving DetailID: 1 [/a.mp3]",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: INFO: Serving DetailID: 1 [/a.mp3]",
+		"[2025/01/31 18:00:01] upnphttp.c:1923:info: Serving DetailID: 1 [/a.mp3]",
+		"[2025/01/31 18:00:01] upnphttp.go:1923: info: Serving DetailID: 1 [/a.mp3]",
+		"[2025/01/31 18:00:01] .c:1923: info: Serving DetailID: 1 [/a.mp3]",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 1 []",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 1 [",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID:1 [/a.mp3]",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 1 [/a.mp3]\n",
+		"[2025/01/31 18:00:01] upnphttp.c:1923: info: Serving DetailID: 1 [/\xff\xfe.mp3]",
+		"[2025/01/31 18:00:01] minidlna.c:1231: debug: HTTP connection from :80",
+		"[2025/01/31 18:00:01] minidlna.c:1231: debug: HTTP connection from 1.2.3.4:",
+		"[2025/01/31 18:00:01] upnphttp.c:280: debug: Client found in cache. []",
+		"[2025/01/31 18:00:01] upnphttp.c:280: debug: Client found elsewhere",
+		"[2025/01/31 18:00:01] albumart.c:350: debug: Found album art in ",
+		"[2025/01/31 18:00:01] scanner.c:731: info: Scanning ",
+		"[2025/01/31 18:00:01] scanner.c:822: info: Scanning  finished (1 files)!",
+		"[2025/01/31 18:00:01] scanner.c:822: info: Scanning /a finished (99999999999999999999 files)!",
+		"[2025/01/31 18:00:01] inotify.c:146: debug: Added watch to  [42]",
+		"[2025/01/31 18:00:01] inotify.c:701: debug: The file /a was was moved here.",
+		"[2025/01/31 18:00:01] inotify.c:701: debug: The file  was created.",
+		"[2025/01/31 18:00:01] inotify.c:701: debug: The file /a was eaten.",
+		"[2025/01/31 18:00:01] upnphttp.c:1067: debug: Range Start-End: 0 - 1023",
+		"[2025/01/31 18:00:01] upnphttp.c:1067: debug: Range Start-End: 0 - -2",
+		"[2025/01/31 18:00:01] upnphttp.c:1067: debug: Range Start-End: -1 - -1",
+		"[2025/01/31 18:00:01] upnphttp.c:1067: debug: Range Start-End: 99999999999999999999 - -1",
+		"[2025/01/31 18:00:01] upnphttp.c:1067: debug: Range Start-End:0 - -1",
+	}
+
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(checkAgainstReference)
+}
+
+func BenchmarkParseLine(b *testing.B) {
+	b.ReportAllocs()
+	for range b.N {
+		for _, line := range benchmarkLines {
+			_, _ = ParseLine(line)
+		}
+	}
+}
+
+func BenchmarkReferenceParseLine(b *testing.B) {
+	b.ReportAllocs()
+	for range b.N {
+		for _, line := range benchmarkLines {
+			_, _ = referenceParseLine(line)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package models
+
+type (
+	// Submission is the outcome of submitting a single track to a scrobbling service.
+	Submission struct {
+		// Track as recorded by the service, including any corrections it made
+		Track         Track
+		Ignored       bool
+		IgnoredReason string
+	}
+)
@@ -0,0 +1,114 @@
+package reconcile
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+)
+
+// Missing returns the recorded plays that are expected to have been scrobbled to the
+// target, but aren't among the scrobbled tracks. A play matches a scrobble of the same
+// artist and track, ignoring case, at most tolerance apart, and every scrobble matches
+// a single play. The closest pairs are matched first, so a track repeated within the
+// tolerance is matched to its own scrobble. Plays are compared with the names the
+// target corrected them to, if any. Only plays the target sent or failed are expected.
+func Missing(
+	plays []history.Play,
+	scrobbled []models.Track,
+	target string,
+	tolerance time.Duration,
+) []history.Play {
+	type candidate struct {
+		play     int
+		scrobble int
+		diff     time.Duration
+	}
+
+	candidates := make([]candidate, 0)
+	isExpected := make([]bool, len(plays))
+	for i, play := range plays {
+		track, ok := expected(play, target)
+		if !ok {
+			continue
+		}
+
+		isExpected[i] = true
+		for j, scrobble := range scrobbled {
+			if same(track, scrobble, tolerance) {
+				candidates = append(candidates, candidate{
+					play:     i,
+					scrobble: j,
+					diff:     track.Timestamp.Sub(scrobble.Timestamp).Abs(),
+				})
+			}
+		}
+	}
+
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return cmp.Compare(a.diff, b.diff)
+	})
+
+	playMatched := make([]bool, len(plays))
+	scrobbleMatched := make([]bool, len(scrobbled))
+	for _, c := range candidates {
+		if playMatched[c.play] || scrobbleMatched[c.scrobble] {
+			continue
+		}
+
+		playMatched[c.play] = true
+		scrobbleMatched[c.scrobble] = true
+	}
+
+	missing := make([]history.Play, 0)
+	for i, play := range plays {
+		if isExpected[i] && !playMatched[i] {
+			missing = append(missing, play)
+		}
+	}
+
+	return missing
+}
+
+// expected returns the track as the target should know it, the
+// boolean is false if the play isn't expected to be scrobbled.
+func expected(play history.Play, target string) (models.Track, bool) {
+	if play.Skipped != "" {
+		return models.Track{}, false
+	}
+
+	// Plays without an outcome for the target can still be waiting for the next track
+	// in boundary mode, or were recorded before the target was configured
+	track := play.Track
+	ok := false
+	for _, outcome := range play.Outcomes {
+		if outcome.Target != target {
+			continue
+		}
+
+		if outcome.Status != history.StatusSent && outcome.Status != history.StatusFailed {
+			return models.Track{}, false
+		}
+
+		ok = true
+
+		if outcome.Corrected.Artist != "" {
+			track.Artist = outcome.Corrected.Artist
+		}
+
+		if outcome.Corrected.Name != "" {
+			track.Name = outcome.Corrected.Name
+		}
+	}
+
+	return track, ok
+}
+
+func same(a, b models.Track, tolerance time.Duration) bool {
+	return strings.EqualFold(a.Artist, b.Artist) &&
+		strings.EqualFold(a.Name, b.Name) &&
+		a.Timestamp.Sub(b.Timestamp).Abs() <= tolerance
+}
@@ -0,0 +1,100 @@
+package reconcile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+)
+
+func TestMissing(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 20, 0, 0, 0, time.UTC)
+	track := func(artist, name string, at time.Time) models.Track {
+		return models.Track{Artist: artist, Name: name, Timestamp: at}
+	}
+
+	sent := []history.Outcome{{Target: "lastfm", Status: history.StatusSent}}
+	plays := []history.Play{
+		// Scrobbled a little later
+		{ID: 1, Track: track("Boards of Canada", "Roygbiv", start), Outcomes: sent},
+		// Played twice, scrobbled once
+		{ID: 2, Track: track("Boards of Canada", "Aquarius", start.Add(time.Minute*3)), Outcomes: sent},
+		{ID: 3, Track: track("Boards of Canada", "Aquarius", start.Add(time.Minute*9)), Outcomes: sent},
+		// Corrected by last.fm
+		{
+			ID:    4,
+			Track: track("Boards Of Canada", "Turquoise Hexagon Sun (remaster)", start.Add(time.Minute*15)),
+			Outcomes: []history.Outcome{{
+				Target:    "lastfm",
+				Status:    history.StatusSent,
+				Corrected: models.Track{Artist: "Boards of Canada", Name: "Turquoise Hexagon Sun"},
+			}},
+		},
+		// Not expected on last.fm
+		{ID: 5, Track: track("Aphex Twin", "Xtal", start.Add(time.Minute*20)), Skipped: history.ReasonNotPlayed},
+		{
+			ID:       6,
+			Track:    track("Aphex Twin", "Xtal", start.Add(time.Minute*21)),
+			Outcomes: []history.Outcome{{Target: "lastfm", Status: history.StatusIgnored}},
+		},
+		{
+			ID:       7,
+			Track:    track("Aphex Twin", "Xtal", start.Add(time.Minute*22)),
+			Outcomes: []history.Outcome{{Target: "lastfm", Status: history.StatusPending}},
+		},
+		// Not sent yet, waiting for the next track, or sent to another target only
+		{ID: 10, Track: track("Aphex Twin", "Pulsewidth", start.Add(time.Minute*40))},
+		// Failed
+		{
+			ID:       8,
+			Track:    track("Aphex Twin", "Ageispolis", start.Add(time.Minute*30)),
+			Outcomes: []history.Outcome{{Target: "lastfm", Status: history.StatusFailed}},
+		},
+		{
+			ID:       9,
+			Track:    track("Aphex Twin", "Tha", start.Add(time.Minute*35)),
+			Outcomes: []history.Outcome{{Target: "librefm", Status: history.StatusSent}},
+		},
+	}
+
+	scrobbled := []models.Track{
+		track("boards of canada", "roygbiv", start.Add(time.Second*30)),
+		track("Boards of Canada", "Aquarius", start.Add(time.Minute*3)),
+		track("Boards of Canada", "Turquoise Hexagon Sun", start.Add(time.Minute*15)),
+		// Too far from the play
+		track("Aphex Twin", "Ageispolis", start.Add(time.Minute*32)),
+	}
+
+	missing := Missing(plays, scrobbled, "lastfm", time.Minute)
+
+	expected := []int64{3, 8}
+	if len(missing) != len(expected) {
+		t.Fatalf("expected plays %v, got %+v", expected, missing)
+	}
+
+	for i, id := range expected {
+		if missing[i].ID != id {
+			t.Errorf("expected play %d, got %d", id, missing[i].ID)
+		}
+	}
+}
+
+func TestMissingRepeated(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 20, 0, 0, 0, time.UTC)
+	sent := []history.Outcome{{Target: "lastfm", Status: history.StatusSent}}
+	track := func(at time.Time) models.Track {
+		return models.Track{Artist: "Boards of Canada", Name: "Olson", Timestamp: at}
+	}
+
+	// Replayed right away, only the second play made it
+	plays := []history.Play{
+		{ID: 1, Track: track(start), Outcomes: sent},
+		{ID: 2, Track: track(start.Add(time.Second * 40)), Outcomes: sent},
+	}
+
+	missing := Missing(plays, []models.Track{track(start.Add(time.Second * 40))}, "lastfm", time.Minute)
+	if len(missing) != 1 || missing[0].ID != 1 {
+		t.Errorf("expected the first play to be missing, got %+v", missing)
+	}
+}
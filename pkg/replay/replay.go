@@ -0,0 +1,199 @@
+package replay
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
+	"github.com/dusnm/minidlna-scrobble/pkg/logparser"
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/rules"
+)
+
+type (
+	// Serve is a "Serving DetailID" entry of a log.
+	Serve struct {
+		Timestamp time.Time
+		DetailID  int
+		Path      string
+	}
+
+	// Play is a serve that counts as a play of the track.
+	Play struct {
+		Serve Serve
+		// The track as looked up, played at the time of the serve
+		Track models.Track
+	}
+
+	// Lookup returns the metadata of the track with the given DetailID.
+	Lookup func(ctx context.Context, id int) (models.Track, error)
+
+	// Skipped is a serve that didn't result in a play, and why.
+	Skipped struct {
+		Serve  Serve
+		Reason string
+	}
+)
+
+// Files returns the log file and its rotated siblings that exist,
+// e.g. minidlna.log.1 and minidlna.log.2.gz.
+func Files(logFile string) ([]string, error) {
+	matches, err := filepath.Glob(logFile + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(matches)+1)
+	for _, match := range matches {
+		// Leftovers of the tailer and editors aren't logs
+		if strings.HasSuffix(match, ".tmp") || strings.HasSuffix(match, ".swp") {
+			continue
+		}
+
+		files = append(files, match)
+	}
+
+	if _, err = os.Stat(logFile); err == nil {
+		files = append(files, logFile)
+	}
+
+	return files, nil
+}
+
+// ReadFile returns the serves logged in the file, gzip compressed files are supported.
+func ReadFile(path string) ([]Serve, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+
+		defer gz.Close()
+
+		r = gz
+	}
+
+	return Serves(r)
+}
+
+// Serves returns every serve in the log that has a timestamp, in the order they were logged.
+func Serves(r io.Reader) ([]Serve, error) {
+	serves := make([]Serve, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, constants.MagicLogValue) {
+			continue
+		}
+
+		parsed, err := logparser.ParseLine(line)
+		if err != nil {
+			continue
+		}
+
+		serving, ok := parsed.Serving()
+		if !ok {
+			continue
+		}
+
+		if parsed.Timestamp.IsZero() {
+			// Without the original time, the play can't be placed in history
+			continue
+		}
+
+		serves = append(serves, Serve{
+			Timestamp: parsed.Timestamp,
+			DetailID:  serving.DetailID,
+			Path:      serving.Path,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return serves, nil
+}
+
+// Plays applies the rules the watcher uses to the serves: a serve is
+// a play if nothing else was served before the track played long enough.
+// Serves of the same track before it ended are part of the same play.
+// The serves must be sorted by time. The last serve counts if now is late enough.
+func Plays(
+	ctx context.Context,
+	serves []Serve,
+	lookup Lookup,
+	r rules.Rules,
+	now time.Time,
+) ([]Play, []Skipped) {
+	plays := make([]Play, 0, len(serves))
+	skipped := make([]Skipped, 0)
+	for i := 0; i < len(serves); i++ {
+		serve := serves[i]
+		track, err := lookup(ctx, serve.DetailID)
+		if err != nil {
+			skipped = append(skipped, Skipped{Serve: serve, Reason: err.Error()})
+			continue
+		}
+
+		// Clients make several requests for one playback
+		end := serve.Timestamp.Add(track.Duration)
+		for i+1 < len(serves) && serves[i+1].DetailID == serve.DetailID && serves[i+1].Timestamp.Before(end) {
+			i++
+			skipped = append(skipped, Skipped{Serve: serves[i], Reason: "same play"})
+		}
+
+		until := now
+		if i+1 < len(serves) {
+			until = serves[i+1].Timestamp
+		}
+
+		if _, ok := r.Delay(track.Duration); !ok {
+			reason := "too short"
+			if track.Duration <= 0 {
+				reason = "unknown duration"
+			}
+
+			skipped = append(skipped, Skipped{Serve: serve, Reason: reason})
+			continue
+		}
+
+		if !r.Eligible(track.Duration, until.Sub(serve.Timestamp)) {
+			skipped = append(skipped, Skipped{Serve: serve, Reason: "not played long enough"})
+			continue
+		}
+
+		track.Timestamp = serve.Timestamp
+		plays = append(plays, Play{Serve: serve, Track: track})
+	}
+
+	return plays, skipped
+}
+
+// Merge orders the serves by time, keeping the order of those logged
+// at the same second, and drops duplicates, e.g. from a rotated log
+// that exists both compressed and uncompressed.
+func Merge(serves []Serve) []Serve {
+	slices.SortStableFunc(serves, func(a, b Serve) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	return slices.CompactFunc(serves, func(a, b Serve) bool {
+		return a.Timestamp.Equal(b.Timestamp) && a.DetailID == b.DetailID
+	})
+}
@@ -0,0 +1,161 @@
+package replay
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/rules"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+type (
+	golden struct {
+		Plays   []goldenPlay    `json:"plays"`
+		Skipped []goldenSkipped `json:"skipped"`
+	}
+
+	goldenPlay struct {
+		Timestamp string `json:"timestamp"`
+		Artist    string `json:"artist"`
+		Name      string `json:"name"`
+	}
+
+	goldenSkipped struct {
+		Timestamp string `json:"timestamp"`
+		DetailID  int    `json:"detail_id"`
+		Reason    string `json:"reason"`
+	}
+)
+
+// Durations of the tracks in testdata, the others aren't in the database
+var durations = map[int]time.Duration{
+	101: time.Minute*5 + time.Second*3,
+	102: time.Minute*4 + time.Second*10,
+	103: time.Minute*3 + time.Second*55,
+	104: time.Minute*4 + time.Second*20,
+	105: time.Minute*4 + time.Second*14,
+	106: time.Minute*3 + time.Second*46,
+	107: time.Minute*3 + time.Second*57,
+	108: time.Second * 20,
+	109: time.Minute*5 + time.Second*3,
+	110: time.Minute * 3,
+}
+
+func lookup(_ context.Context, id int) (models.Track, error) {
+	d, ok := durations[id]
+	if !ok {
+		return models.Track{}, sql.ErrNoRows
+	}
+
+	return models.Track{
+		Artist:   "Portishead",
+		Name:     "Track " + strconv.Itoa(id),
+		Duration: d,
+	}, nil
+}
+
+func TestRotatedLogsGolden(t *testing.T) {
+	dir := filepath.Join("testdata", "rotated")
+	files, err := Files(filepath.Join(dir, "minidlna.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 3 {
+		t.Fatalf("expected the log and 2 rotated files, got %q", files)
+	}
+
+	serves := make([]Serve, 0)
+	for _, f := range files {
+		fileServes, err := ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		serves = append(serves, fileServes...)
+	}
+
+	now := time.Date(2025, time.March, 1, 8, 20, 0, 0, time.Local)
+	plays, skipped := Plays(context.Background(), Merge(serves), lookup, rules.Default(), now)
+
+	actual := golden{
+		Plays:   make([]goldenPlay, 0, len(plays)),
+		Skipped: make([]goldenSkipped, 0, len(skipped)),
+	}
+
+	for _, play := range plays {
+		actual.Plays = append(actual.Plays, goldenPlay{
+			Timestamp: play.Track.Timestamp.Format(time.DateTime),
+			Artist:    play.Track.Artist,
+			Name:      play.Track.Name,
+		})
+	}
+
+	for _, s := range skipped {
+		actual.Skipped = append(actual.Skipped, goldenSkipped{
+			Timestamp: s.Serve.Timestamp.Format(time.DateTime),
+			DetailID:  s.Serve.DetailID,
+			Reason:    s.Reason,
+		})
+	}
+
+	buff, err := json.MarshalIndent(actual, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buff = append(buff, '\n')
+	path := filepath.Join(dir, "plays.golden.json")
+	if *update {
+		if err = os.WriteFile(path, buff, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(expected) != string(buff) {
+		t.Errorf("plays differ from %s, got:\n%s", path, buff)
+	}
+}
+
+func TestPlaysRepeatedServes(t *testing.T) {
+	start := time.Date(2025, time.March, 2, 19, 0, 0, 0, time.Local)
+	serves := []Serve{
+		{Timestamp: start, DetailID: 101},
+		{Timestamp: start.Add(time.Second * 2), DetailID: 101},
+		{Timestamp: start.Add(time.Minute * 3), DetailID: 101},
+		{Timestamp: start.Add(time.Minute * 5), DetailID: 102},
+		// Played again after it ended
+		{Timestamp: start.Add(time.Minute * 10), DetailID: 101},
+	}
+
+	plays, skipped := Plays(context.Background(), serves, lookup, rules.Default(), start.Add(time.Minute*20))
+
+	expected := []time.Time{start, start.Add(time.Minute * 5), start.Add(time.Minute * 10)}
+	if len(plays) != len(expected) {
+		t.Fatalf("expected %d plays, got %+v", len(expected), plays)
+	}
+
+	for i, play := range plays {
+		if !play.Track.Timestamp.Equal(expected[i]) {
+			t.Errorf("expected play %d at %v, got %v", i, expected[i], play.Track.Timestamp)
+		}
+	}
+
+	if len(skipped) != 2 || skipped[0].Reason != "same play" || skipped[1].Reason != "same play" {
+		t.Errorf("expected the repeated serves to be skipped, got %+v", skipped)
+	}
+}
@@ -0,0 +1,358 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/migrations"
+	"github.com/rs/zerolog"
+)
+
+// Outcomes of submitting a play to a scrobbling service, the same as the states of the queue
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+	StatusIgnored = "ignored"
+	// The play wasn't submitted at all
+	StatusSkipped = "skipped"
+)
+
+// ReasonNotPlayed is why a play that was cut short by the next one isn't submitted.
+const ReasonNotPlayed = "not played long enough"
+
+var ErrUnknownStatus = errors.New("unknown status, use one of: pending, sent, failed, ignored, skipped")
+
+type (
+	// Play is a play detected in the log.
+	Play struct {
+		ID       int64
+		DetailID int
+		Path     string
+		Track    models.Track
+		// Why the play wasn't submitted, empty if it was
+		Skipped  string
+		Outcomes []Outcome
+	}
+
+	// Outcome is what became of the play at a scrobbling service.
+	Outcome struct {
+		Target string
+		Status string
+		// Why the play was ignored, failed, or is being retried
+		Reason string
+		// The artist, name and album as corrected by the service,
+		// the zero value if it didn't make any corrections
+		Corrected models.Track
+		UpdatedAt time.Time
+	}
+
+	// Filter selects plays, the zero value selects all of them.
+	Filter struct {
+		// Played at or after since, and before until
+		Since time.Time
+		Until time.Time
+		// Part of the artist's name, case insensitive
+		Artist string
+		Status string
+		// Only the latest plays, 0 means no limit
+		Limit int
+	}
+
+	Repository struct {
+		db     *sql.DB
+		logger zerolog.Logger
+	}
+)
+
+// Every entry is a migration step
+var steps = []string{
+	`CREATE TABLE IF NOT EXISTS plays (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		detail_id INTEGER NOT NULL DEFAULT 0,
+		path TEXT NOT NULL DEFAULT '',
+		artist TEXT NOT NULL,
+		name TEXT NOT NULL,
+		album TEXT NOT NULL DEFAULT '',
+		duration INTEGER NOT NULL DEFAULT 0,
+		number INTEGER NOT NULL DEFAULT 0,
+		played_at INTEGER NOT NULL,
+		skipped TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS plays_played_at ON plays (played_at);
+	CREATE TABLE IF NOT EXISTS outcomes (
+		play_id INTEGER NOT NULL REFERENCES plays (id),
+		target TEXT NOT NULL,
+		status TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		corrected_artist TEXT NOT NULL DEFAULT '',
+		corrected_name TEXT NOT NULL DEFAULT '',
+		corrected_album TEXT NOT NULL DEFAULT '',
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (play_id, target)
+	);`,
+}
+
+const (
+	insertQuery = `INSERT INTO plays
+		(detail_id, path, artist, name, album, duration, number, played_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	skipQuery    = "UPDATE plays SET skipped = ?, updated_at = ? WHERE id = ?"
+	unqueueQuery = "DELETE FROM outcomes WHERE play_id = ? AND status = ?"
+	outcomeQuery = `INSERT INTO outcomes
+		(play_id, target, status, reason, corrected_artist, corrected_name, corrected_album, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (play_id, target) DO UPDATE SET
+			status = excluded.status,
+			reason = excluded.reason,
+			corrected_artist = excluded.corrected_artist,
+			corrected_name = excluded.corrected_name,
+			corrected_album = excluded.corrected_album,
+			updated_at = excluded.updated_at`
+	// The latest plays matching the filter, with their outcomes, oldest first
+	selectQuery = `SELECT p.id, p.detail_id, p.path, p.artist, p.name, p.album, p.duration, p.number,
+			p.played_at, p.skipped, o.target, o.status, o.reason,
+			o.corrected_artist, o.corrected_name, o.corrected_album, o.updated_at
+		FROM (SELECT * FROM plays WHERE %s ORDER BY played_at DESC, id DESC LIMIT ?) p
+		LEFT JOIN outcomes o ON o.play_id = p.id
+		ORDER BY p.played_at, p.id, o.target`
+)
+
+func New(
+	db *sql.DB,
+	logger zerolog.Logger,
+) (*Repository, error) {
+	r := &Repository{
+		db:     db,
+		logger: logger,
+	}
+
+	if err := migrations.Apply(db, steps); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Repository) Close() error {
+	r.logger.Info().Msg("closing")
+
+	return r.db.Close()
+}
+
+// Add records the play, and returns its ID.
+func (r *Repository) Add(ctx context.Context, play Play) (int64, error) {
+	now := time.Now().Unix()
+	result, err := r.db.ExecContext(
+		ctx,
+		insertQuery,
+		play.DetailID,
+		play.Path,
+		play.Track.Artist,
+		play.Track.Name,
+		play.Track.Album,
+		play.Track.Duration.Milliseconds(),
+		play.Track.Number,
+		play.Track.Timestamp.Unix(),
+		now,
+		now,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// Skip records why the play isn't submitted. Outcomes
+// still pending are dropped, they won't be submitted either.
+func (r *Repository) Skip(ctx context.Context, id int64, reason string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, skipQuery, reason, time.Now().Unix(), id); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, unqueueQuery, id, StatusPending); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Unskip clears why the play wasn't submitted, for when it's submitted after all.
+func (r *Repository) Unskip(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, skipQuery, "", time.Now().Unix(), id)
+
+	return err
+}
+
+// SetOutcome records what became of the play at the target, replacing what was recorded before.
+func (r *Repository) SetOutcome(ctx context.Context, id int64, outcome Outcome) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		outcomeQuery,
+		id,
+		outcome.Target,
+		outcome.Status,
+		outcome.Reason,
+		outcome.Corrected.Artist,
+		outcome.Corrected.Name,
+		outcome.Corrected.Album,
+		time.Now().Unix(),
+	)
+
+	return err
+}
+
+// Find returns the plays matching the filter, oldest first.
+func (r *Repository) Find(ctx context.Context, filter Filter) ([]Play, error) {
+	conditions := []string{"1 = 1"}
+	args := make([]any, 0, 5)
+
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "played_at >= ?")
+		args = append(args, filter.Since.Unix())
+	}
+
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "played_at < ?")
+		args = append(args, filter.Until.Unix())
+	}
+
+	if filter.Artist != "" {
+		conditions = append(conditions, `artist LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(filter.Artist)+"%")
+	}
+
+	switch filter.Status {
+	case "":
+	case StatusSkipped:
+		conditions = append(conditions, "skipped != ''")
+	case StatusPending, StatusSent, StatusFailed, StatusIgnored:
+		conditions = append(
+			conditions,
+			"EXISTS (SELECT 1 FROM outcomes WHERE outcomes.play_id = plays.id AND outcomes.status = ?)",
+		)
+		args = append(args, filter.Status)
+	default:
+		return nil, ErrUnknownStatus
+	}
+
+	// A negative limit is no limit to SQLite
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+
+	args = append(args, limit)
+	query := fmt.Sprintf(selectQuery, strings.Join(conditions, " AND "))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	plays := make([]Play, 0)
+	for rows.Next() {
+		var (
+			play      Play
+			duration  int64
+			playedAt  int64
+			target    sql.NullString
+			status    sql.NullString
+			reason    sql.NullString
+			artist    sql.NullString
+			name      sql.NullString
+			album     sql.NullString
+			updatedAt sql.NullInt64
+		)
+
+		err = rows.Scan(
+			&play.ID,
+			&play.DetailID,
+			&play.Path,
+			&play.Track.Artist,
+			&play.Track.Name,
+			&play.Track.Album,
+			&duration,
+			&play.Track.Number,
+			&playedAt,
+			&play.Skipped,
+			&target,
+			&status,
+			&reason,
+			&artist,
+			&name,
+			&album,
+			&updatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// A play has a row for each of its outcomes
+		if len(plays) == 0 || plays[len(plays)-1].ID != play.ID {
+			play.Track.Duration = time.Duration(duration) * time.Millisecond
+			play.Track.Timestamp = time.Unix(playedAt, 0)
+			play.Outcomes = make([]Outcome, 0)
+			plays = append(plays, play)
+		}
+
+		if !target.Valid {
+			continue
+		}
+
+		last := &plays[len(plays)-1]
+		last.Outcomes = append(last.Outcomes, Outcome{
+			Target: target.String,
+			Status: status.String,
+			Reason: reason.String,
+			Corrected: models.Track{
+				Artist: artist.String,
+				Name:   name.String,
+				Album:  album.String,
+			},
+			UpdatedAt: time.Unix(updatedAt.Int64, 0),
+		})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return plays, nil
+}
+
+// Corrections returns the artist, name and album the service recorded
+// the track with, or the zero value if they're the same as submitted.
+func Corrections(submitted models.Track, recorded models.Track) models.Track {
+	if submitted.Artist == recorded.Artist && submitted.Name == recorded.Name && submitted.Album == recorded.Album {
+		return models.Track{}
+	}
+
+	return models.Track{
+		Artist: recorded.Artist,
+		Name:   recorded.Name,
+		Album:  recorded.Album,
+	}
+}
+
+// escapeLike makes the wildcards of LIKE match literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
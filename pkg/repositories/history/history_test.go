@@ -0,0 +1,112 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/rs/zerolog"
+
+	_ "github.com/glebarez/go-sqlite"
+)
+
+func TestFind(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := New(db, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	ctx := context.Background()
+	start := time.Date(2025, time.March, 1, 20, 0, 0, 0, time.Local)
+	tracks := []models.Track{
+		{Artist: "Boards of Canada", Name: "Roygbiv", Timestamp: start},
+		{Artist: "Aphex Twin", Name: "Xtal", Timestamp: start.Add(time.Minute * 5)},
+		{Artist: "Boards of Canada", Name: "Turquoise Hexagon Sun", Timestamp: start.Add(time.Minute * 10)},
+		{Artist: "50% Off", Name: "Sale", Timestamp: start.Add(time.Minute * 15)},
+	}
+
+	ids := make([]int64, 0, len(tracks))
+	for _, track := range tracks {
+		id, err := r.Add(ctx, Play{Track: track})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = r.SetOutcome(ctx, id, Outcome{Target: "lastfm", Status: StatusPending}); err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = r.SetOutcome(ctx, ids[0], Outcome{
+		Target:    "lastfm",
+		Status:    StatusSent,
+		Corrected: models.Track{Artist: "Boards of Canada", Name: "Roygbiv (Remastered)"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Skip(ctx, ids[1], "too short"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter   Filter
+		expected []int64
+	}{
+		{filter: Filter{}, expected: ids},
+		{filter: Filter{Limit: 2}, expected: ids[2:]},
+		{filter: Filter{Artist: "boards"}, expected: []int64{ids[0], ids[2]}},
+		// Wildcards match literally
+		{filter: Filter{Artist: "%"}, expected: []int64{ids[3]}},
+		{filter: Filter{Status: StatusSent}, expected: []int64{ids[0]}},
+		{filter: Filter{Status: StatusPending}, expected: []int64{ids[2], ids[3]}},
+		{filter: Filter{Status: StatusSkipped}, expected: []int64{ids[1]}},
+		{filter: Filter{Since: start.Add(time.Minute * 5), Until: start.Add(time.Minute * 15)}, expected: ids[1:3]},
+	}
+
+	for _, test := range tests {
+		plays, err := r.Find(ctx, test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := make([]int64, 0, len(plays))
+		for _, play := range plays {
+			actual = append(actual, play.ID)
+		}
+
+		if !slices.Equal(actual, test.expected) {
+			t.Errorf("%+v: expected %v, got %v", test.filter, test.expected, actual)
+		}
+	}
+
+	plays, err := r.Find(ctx, Filter{Limit: 1, Status: StatusSent})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plays) != 1 || len(plays[0].Outcomes) != 1 || plays[0].Outcomes[0].Corrected.Name != "Roygbiv (Remastered)" {
+		t.Errorf("expected the corrected outcome, got %+v", plays)
+	}
+
+	if plays, _ = r.Find(ctx, Filter{Status: StatusSkipped}); len(plays[0].Outcomes) != 0 {
+		t.Errorf("expected the pending outcome of the skipped play to be dropped, got %+v", plays[0].Outcomes)
+	}
+
+	if _, err = r.Find(ctx, Filter{Status: "lost"}); err != ErrUnknownStatus {
+		t.Errorf("expected %v, got %v", ErrUnknownStatus, err)
+	}
+}
@@ -44,6 +44,8 @@ func (r *Repository) Close() error {
 	return r.selectDetailsStmt.Close()
 }
 
+// GetByID returns the metadata of the track, the timestamp
+// is left for the caller to set to when the track was played.
 func (r *Repository) GetByID(ctx context.Context, ID int) (models.Track, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
@@ -54,7 +56,7 @@ func (r *Repository) GetByID(ctx context.Context, ID int) (models.Track, error)
 		artist   string
 		album    string
 		title    string
-		duration string
+		duration sql.NullString
 		track    int
 	)
 
@@ -69,17 +71,20 @@ func (r *Repository) GetByID(ctx context.Context, ID int) (models.Track, error)
 		return models.Track{}, err
 	}
 
-	d, err := helpers.ParseDBDuration(duration)
-	if err != nil {
-		return models.Track{}, err
+	// The duration is left at zero when minidlna doesn't know it
+	var d time.Duration
+	if duration.Valid && duration.String != "" {
+		d, err = helpers.ParseDBDuration(duration.String)
+		if err != nil {
+			return models.Track{}, err
+		}
 	}
 
 	return models.Track{
-		Artist:    helpers.ReplaceSpecialChars(artist),
-		Name:      helpers.ReplaceSpecialChars(title),
-		Timestamp: time.Now(),
-		Album:     helpers.ReplaceSpecialChars(album),
-		Duration:  d,
-		Number:    track,
+		Artist:   helpers.ReplaceSpecialChars(artist),
+		Name:     helpers.ReplaceSpecialChars(title),
+		Album:    helpers.ReplaceSpecialChars(album),
+		Duration: d,
+		Number:   track,
 	}, nil
 }
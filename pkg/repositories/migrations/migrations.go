@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+// Apply runs the steps that weren't applied to the database yet, each in its
+// own transaction. The index of the last applied step is tracked with the
+// user_version pragma, so steps can only ever be appended.
+func Apply(db *sql.DB, steps []string) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(steps); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(steps[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		// Pragmas don't support placeholders, but the value is an integer we control
+		if _, err = tx.Exec("PRAGMA user_version = " + strconv.Itoa(i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
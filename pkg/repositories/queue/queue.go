@@ -0,0 +1,291 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/migrations"
+	"github.com/rs/zerolog"
+)
+
+const (
+	StatePending = "pending"
+	StateSent    = "sent"
+	StateFailed  = "failed"
+	StateIgnored = "ignored"
+)
+
+type (
+	Entry struct {
+		ID int64
+		// ID of the play in the history, 0 if it isn't recorded
+		PlayID int64
+		// Name of the scrobbling service the entry is meant for
+		Target    string
+		Track     models.Track
+		State     string
+		Attempts  int
+		DueAt     time.Time
+		LastError string
+	}
+
+	Repository struct {
+		db     *sql.DB
+		logger zerolog.Logger
+	}
+)
+
+// Every entry is a migration step
+var steps = []string{
+	`CREATE TABLE IF NOT EXISTS queue (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		artist TEXT NOT NULL,
+		name TEXT NOT NULL,
+		album TEXT NOT NULL DEFAULT '',
+		duration INTEGER NOT NULL DEFAULT 0,
+		number INTEGER NOT NULL DEFAULT 0,
+		timestamp INTEGER NOT NULL,
+		state TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		due_at INTEGER NOT NULL,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS queue_state_due_at ON queue (state, due_at);`,
+	// Every target gets its own entry, so each can be retried independently.
+	// Entries queued before are given their target by New.
+	`ALTER TABLE queue ADD COLUMN target TEXT NOT NULL DEFAULT '';
+	DROP INDEX IF EXISTS queue_state_due_at;
+	CREATE INDEX IF NOT EXISTS queue_target_state_due_at ON queue (target, state, due_at);`,
+	// Entries are tied to the play in the history they belong to, if any
+	`ALTER TABLE queue ADD COLUMN play_id INTEGER NOT NULL DEFAULT 0;`,
+}
+
+const (
+	insertQuery = `INSERT INTO queue
+		(play_id, target, artist, name, album, duration, number, timestamp, state, due_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	removeQuery    = "DELETE FROM queue WHERE id = ? AND state = ?"
+	selectDueQuery = `SELECT id, play_id, target, artist, name, album, duration, number, timestamp, state, attempts, due_at, last_error
+		FROM queue WHERE target = ? AND state = ? AND due_at <= ? ORDER BY due_at, id LIMIT ?`
+	// Entries for the target played in a time span, that weren't ignored or failed
+	selectPlayedQuery = `SELECT id, play_id, target, artist, name, album, duration, number, timestamp, state, attempts, due_at, last_error
+		FROM queue WHERE target = ? AND state IN (?, ?) AND timestamp >= ? AND timestamp < ? ORDER BY timestamp, id`
+	selectNextDueQuery  = "SELECT MIN(due_at) FROM queue WHERE target = ? AND state = ?"
+	updateStateQuery    = "UPDATE queue SET state = ?, last_error = ?, updated_at = ? WHERE id = ?"
+	retryQuery          = "UPDATE queue SET attempts = attempts + 1, due_at = ?, last_error = ?, updated_at = ? WHERE id = ?"
+	backfillTargetQuery = "UPDATE queue SET target = ? WHERE target = ''"
+)
+
+// New migrates the database, entries queued when there was a single
+// backend were meant for it, and are given legacyTarget as their target.
+func New(
+	db *sql.DB,
+	legacyTarget string,
+	logger zerolog.Logger,
+) (*Repository, error) {
+	r := &Repository{
+		db:     db,
+		logger: logger,
+	}
+
+	if err := migrations.Apply(db, steps); err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(backfillTargetQuery, legacyTarget); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Repository) Close() error {
+	r.logger.Info().Msg("closing")
+
+	return r.db.Close()
+}
+
+// Add persists the track as pending for every target, to be sent no earlier than dueAt.
+// The IDs of the entries are in the same order as the targets.
+func (r *Repository) Add(
+	ctx context.Context,
+	playID int64,
+	track models.Track,
+	dueAt time.Time,
+	targets []string,
+) ([]int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	ids := make([]int64, 0, len(targets))
+	for _, target := range targets {
+		result, err := tx.ExecContext(
+			ctx,
+			insertQuery,
+			playID,
+			target,
+			track.Artist,
+			track.Name,
+			track.Album,
+			track.Duration.Milliseconds(),
+			track.Number,
+			track.Timestamp.Unix(),
+			StatePending,
+			dueAt.Unix(),
+			now,
+			now,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Remove deletes the entries, but only those that haven't been processed yet.
+func (r *Repository) Remove(ctx context.Context, ids ...int64) error {
+	for _, id := range ids {
+		if _, err := r.db.ExecContext(ctx, removeQuery, id, StatePending); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Due returns at most limit pending entries for the target that are due by now, oldest first.
+func (r *Repository) Due(ctx context.Context, target string, now time.Time, limit int) ([]Entry, error) {
+	rows, err := r.db.QueryContext(ctx, selectDueQuery, target, StatePending, now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanEntries(rows)
+}
+
+// Played returns the entries for the target that are pending or were sent,
+// of tracks played at or after since and before until, oldest first.
+func (r *Repository) Played(ctx context.Context, target string, since, until time.Time) ([]Entry, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		selectPlayedQuery,
+		target,
+		StatePending,
+		StateSent,
+		since.Unix(),
+		until.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanEntries(rows)
+}
+
+// NextDue returns the time at which the earliest pending entry for the target becomes due.
+// The boolean is false if there are no pending entries.
+func (r *Repository) NextDue(ctx context.Context, target string) (time.Time, bool, error) {
+	var dueAt sql.NullInt64
+	if err := r.db.QueryRowContext(ctx, selectNextDueQuery, target, StatePending).Scan(&dueAt); err != nil {
+		return time.Time{}, false, err
+	}
+
+	if !dueAt.Valid {
+		return time.Time{}, false, nil
+	}
+
+	return time.Unix(dueAt.Int64, 0), true, nil
+}
+
+func (r *Repository) MarkSent(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, updateStateQuery, StateSent, "", time.Now().Unix(), id)
+
+	return err
+}
+
+// MarkIgnored records that the service received the entry, but refused to count it.
+func (r *Repository) MarkIgnored(ctx context.Context, id int64, reason string) error {
+	_, err := r.db.ExecContext(ctx, updateStateQuery, StateIgnored, reason, time.Now().Unix(), id)
+
+	return err
+}
+
+func (r *Repository) MarkFailed(ctx context.Context, id int64, reason string) error {
+	_, err := r.db.ExecContext(ctx, updateStateQuery, StateFailed, reason, time.Now().Unix(), id)
+
+	return err
+}
+
+// Retry keeps the entry pending, but postpones it until dueAt.
+func (r *Repository) Retry(ctx context.Context, id int64, dueAt time.Time, reason string) error {
+	_, err := r.db.ExecContext(ctx, retryQuery, dueAt.Unix(), reason, time.Now().Unix(), id)
+
+	return err
+}
+
+func scanEntries(rows *sql.Rows) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	for rows.Next() {
+		var (
+			entry     Entry
+			duration  int64
+			timestamp int64
+			dueAt     int64
+		)
+
+		err := rows.Scan(
+			&entry.ID,
+			&entry.PlayID,
+			&entry.Target,
+			&entry.Track.Artist,
+			&entry.Track.Name,
+			&entry.Track.Album,
+			&duration,
+			&entry.Track.Number,
+			&timestamp,
+			&entry.State,
+			&entry.Attempts,
+			&dueAt,
+			&entry.LastError,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Track.Duration = time.Duration(duration) * time.Millisecond
+		entry.Track.Timestamp = time.Unix(timestamp, 0)
+		entry.DueAt = time.Unix(dueAt, 0)
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
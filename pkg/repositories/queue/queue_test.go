@@ -0,0 +1,219 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/migrations"
+	"github.com/rs/zerolog"
+
+	_ "github.com/glebarez/go-sqlite"
+)
+
+func TestQueue(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "queue.db")
+	open := func() *Repository {
+		t.Helper()
+
+		db, err := sql.Open("sqlite", file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := New(db, "lastfm", zerolog.Nop())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return r
+	}
+
+	ctx := context.Background()
+	now := time.Date(2025, time.March, 1, 20, 0, 0, 0, time.Local)
+	tracks := []models.Track{
+		{Artist: "Boards of Canada", Name: "Roygbiv", Duration: time.Millisecond * 151500, Timestamp: now.Add(-time.Hour)},
+		{Artist: "Aphex Twin", Name: "Xtal", Timestamp: now.Add(-time.Minute)},
+		{Artist: "Boards of Canada", Name: "Olson", Timestamp: now},
+	}
+
+	r := open()
+	ids := make([]int64, 0, len(tracks))
+	for i, track := range tracks {
+		added, err := r.Add(ctx, 0, track, now.Add(time.Duration(i-1)*time.Minute), []string{"lastfm", "listenbrainz"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, added[0])
+	}
+
+	// Pending entries survive a restart
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r = open()
+	defer r.Close()
+
+	entries, err := r.Due(ctx, "lastfm", now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].ID != ids[0] || entries[1].ID != ids[1] {
+		t.Fatalf("expected the first two entries to be due, got %+v", entries)
+	}
+
+	if entries[0].Track != tracks[0] {
+		t.Errorf("expected %+v, got %+v", tracks[0], entries[0].Track)
+	}
+
+	if err = r.MarkSent(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Retry(ctx, ids[1], now.Add(time.Minute*5), "service unavailable"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Processed entries can't be removed
+	if err = r.Remove(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Remove(ctx, ids[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	dueAt, ok, err := r.NextDue(ctx, "lastfm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || !dueAt.Equal(now.Add(time.Minute*5)) {
+		t.Errorf("expected the retried entry to be due next, got %v, %v", dueAt, ok)
+	}
+
+	entries, err = r.Due(ctx, "lastfm", now.Add(time.Minute*5), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Attempts != 1 || entries[0].LastError != "service unavailable" {
+		t.Errorf("expected the retried entry, got %+v", entries)
+	}
+
+	// Every target has its own entries
+	entries, err = r.Due(ctx, "listenbrainz", now.Add(time.Minute*5), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 3 || entries[0].Target != "listenbrainz" {
+		t.Errorf("expected the entries for listenbrainz to be untouched, got %+v", entries)
+	}
+}
+
+func TestNewBackfillsTarget(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A queue from before entries had a target
+	if err = migrations.Apply(db, steps[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2025, time.March, 1, 20, 0, 0, 0, time.Local).Unix()
+	_, err = db.Exec(
+		`INSERT INTO queue (artist, name, timestamp, state, due_at, created_at, updated_at)
+		VALUES ('Boards of Canada', 'Roygbiv', ?, ?, ?, ?, ?)`,
+		now, StatePending, now, now, now,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := New(db, "listenbrainz", zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	ctx := context.Background()
+	entries, err := r.Due(ctx, "listenbrainz", time.Unix(now, 0), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Track.Name != "Roygbiv" {
+		t.Errorf("expected the entry to be for listenbrainz, got %+v", entries)
+	}
+
+	if _, ok, err := r.NextDue(ctx, "lastfm"); err != nil || ok {
+		t.Errorf("expected nothing pending for lastfm, got %v, %v", ok, err)
+	}
+}
+
+func TestPlayed(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := New(db, "lastfm", zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	ctx := context.Background()
+	start := time.Date(2025, time.March, 1, 20, 0, 0, 0, time.Local)
+	tracks := []models.Track{
+		{Artist: "Boards of Canada", Name: "Roygbiv", Timestamp: start.Add(-time.Minute)},
+		{Artist: "Boards of Canada", Name: "Aquarius", Timestamp: start},
+		{Artist: "Aphex Twin", Name: "Xtal", Timestamp: start.Add(time.Minute * 5)},
+		{Artist: "Aphex Twin", Name: "Ageispolis", Timestamp: start.Add(time.Minute * 10)},
+		{Artist: "Boards of Canada", Name: "Olson", Timestamp: start.Add(time.Minute * 15)},
+	}
+
+	ids := make([]int64, 0, len(tracks))
+	for _, track := range tracks {
+		added, err := r.Add(ctx, 0, track, start, []string{"lastfm", "listenbrainz"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, added[0])
+	}
+
+	if err = r.MarkSent(ctx, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.MarkFailed(ctx, ids[2], "invalid parameters"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := r.Played(ctx, "lastfm", start, start.Add(time.Minute*15))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Track.Name)
+	}
+
+	if expected := []string{"Aquarius", "Ageispolis"}; !slices.Equal(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
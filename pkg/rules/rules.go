@@ -0,0 +1,66 @@
+package rules
+
+import (
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/config"
+)
+
+type (
+	// Rules decide when a play counts as a listen.
+	Rules struct {
+		// Tracks this long or shorter are never scrobbled
+		MinDuration time.Duration
+		// Fraction of the track that has to be played
+		Threshold float64
+		// Playing this long is always enough, regardless
+		// of the track duration. Zero means there's no cap.
+		MaxDelay time.Duration
+		// How long tracks without a known duration have to play,
+		// zero means they're never scrobbled
+		UnknownDurationAfter time.Duration
+	}
+)
+
+// New returns the rules as configured.
+func New(cfg config.Rules) Rules {
+	return Rules{
+		MinDuration:          time.Duration(cfg.MinDuration),
+		Threshold:            cfg.Percentage / 100,
+		MaxDelay:             time.Duration(cfg.MaxDelay),
+		UnknownDurationAfter: time.Duration(cfg.UnknownDurationAfter),
+	}
+}
+
+// Default returns the rules last.fm recommends.
+func Default() Rules {
+	return New(config.DefaultRules())
+}
+
+// Delay returns how long a track has to play to count as listened.
+// A duration of zero means it's unknown. The boolean is false if
+// the track can't be scrobbled at all.
+func (r Rules) Delay(duration time.Duration) (time.Duration, bool) {
+	if duration <= 0 {
+		return r.UnknownDurationAfter, r.UnknownDurationAfter > 0
+	}
+
+	if duration <= r.MinDuration {
+		// Not worth scrobbling
+		return 0, false
+	}
+
+	delay := time.Duration(float64(duration) * r.Threshold)
+	if r.MaxDelay > 0 && delay >= r.MaxDelay {
+		delay = r.MaxDelay
+	}
+
+	return delay, true
+}
+
+// Eligible reports whether playing the track for elapsed counts as a listen.
+func (r Rules) Eligible(duration time.Duration, elapsed time.Duration) bool {
+	delay, ok := r.Delay(duration)
+
+	return ok && elapsed >= delay
+}
@@ -0,0 +1,78 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/config"
+)
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		delay    time.Duration
+		ok       bool
+	}{
+		{duration: 0, ok: false},
+		{duration: time.Second * 30, ok: false},
+		{duration: time.Second * 31, delay: time.Millisecond * 15500, ok: true},
+		{duration: time.Minute * 3, delay: time.Second * 90, ok: true},
+		{duration: time.Minute * 8, delay: time.Minute * 4, ok: true},
+		{duration: time.Hour, delay: time.Minute * 4, ok: true},
+	}
+
+	r := Default()
+	for _, test := range tests {
+		delay, ok := r.Delay(test.duration)
+		if ok != test.ok || delay != test.delay {
+			t.Errorf("%v: expected %v %v, got %v %v", test.duration, test.delay, test.ok, delay, ok)
+		}
+	}
+}
+
+func TestEligible(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		elapsed  time.Duration
+		eligible bool
+	}{
+		{duration: time.Minute * 3, elapsed: time.Second * 89, eligible: false},
+		{duration: time.Minute * 3, elapsed: time.Second * 90, eligible: true},
+		{duration: time.Hour, elapsed: time.Minute * 4, eligible: true},
+		{duration: time.Second * 20, elapsed: time.Hour, eligible: false},
+	}
+
+	r := Default()
+	for _, test := range tests {
+		if eligible := r.Eligible(test.duration, test.elapsed); eligible != test.eligible {
+			t.Errorf("%v played for %v: expected %v, got %v", test.duration, test.elapsed, test.eligible, eligible)
+		}
+	}
+}
+
+func TestConfigured(t *testing.T) {
+	r := New(config.Rules{
+		MinDuration:          config.Duration(time.Minute),
+		Percentage:           80,
+		UnknownDurationAfter: config.Duration(time.Minute * 2),
+	})
+
+	tests := []struct {
+		duration time.Duration
+		delay    time.Duration
+		ok       bool
+	}{
+		{duration: 0, delay: time.Minute * 2, ok: true},
+		{duration: time.Minute, ok: false},
+		{duration: time.Minute * 5, delay: time.Minute * 4, ok: true},
+		// No cap
+		{duration: time.Hour, delay: time.Minute * 48, ok: true},
+	}
+
+	for _, test := range tests {
+		delay, ok := r.Delay(test.duration)
+		if ok != test.ok || delay != test.delay {
+			t.Errorf("%v: expected %v %v, got %v %v", test.duration, test.delay, test.ok, delay, ok)
+		}
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/dusnm/minidlna-scrobble/pkg/config"
-	"github.com/dusnm/minidlna-scrobble/pkg/constants"
 	"github.com/dusnm/minidlna-scrobble/pkg/helpers"
 )
 
@@ -60,8 +59,10 @@ func (s *Service) GetToken(ctx context.Context) (string, error) {
 	query.Add("api_key", s.cfg.APIKey)
 	query.Add("api_sig", helpers.CalculateSignature(query, s.cfg.SharedSecret))
 
-	// The URL is coming from a constant, parsing can never fail
-	u, _ := url.Parse(constants.APIBaseURL)
+	u, err := url.Parse(s.cfg.APIURL)
+	if err != nil {
+		return "", err
+	}
 
 	u.RawQuery = query.Encode()
 
@@ -113,8 +114,10 @@ func (s *Service) GetSessionKey(ctx context.Context, token string) (SessionRespo
 	query.Add("api_key", s.cfg.APIKey)
 	query.Add("api_sig", helpers.CalculateSignature(query, s.cfg.SharedSecret))
 
-	// The URL is coming from a constant, parsing can never fail
-	u, _ := url.Parse(constants.APIBaseURL)
+	u, err := url.Parse(s.cfg.APIURL)
+	if err != nil {
+		return SessionResponse{}, err
+	}
 
 	u.RawQuery = query.Encode()
 
@@ -157,3 +160,21 @@ func (s *Service) GetSessionKey(ctx context.Context, token string) (SessionRespo
 
 	return data, nil
 }
+
+// AuthorizationURL returns the page where the user grants access to the token.
+func (s *Service) AuthorizationURL(token string) (string, error) {
+	u, err := url.Parse(s.cfg.AuthURL)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path += "/auth/"
+
+	query := u.Query()
+	query.Add("api_key", s.cfg.APIKey)
+	query.Add("token", token)
+
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
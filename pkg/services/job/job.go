@@ -3,134 +3,525 @@ package job
 import (
 	"context"
 	"errors"
-	"net/url"
-	"syscall"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/dusnm/minidlna-scrobble/pkg/clock"
 	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/queue"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobble"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobbler"
 	"github.com/rs/zerolog"
 )
 
+const (
+	// How many due entries are submitted at once, the
+	// lowest batch size limit of the supported services
+	batchSize = scrobble.MaxBatchSize
+
+	// How often the queue is checked for entries that became due,
+	// e.g. ones that were postponed because the service was unreachable
+	pollInterval = time.Minute
+
+	minRetryDelay = time.Second * 30
+	maxRetryDelay = time.Hour
+)
+
+// ErrCancelled should be used as the cancellation cause of a job's
+// context when the play it belongs to no longer counts, e.g. the track
+// was changed before the delay elapsed. Jobs cancelled for any other
+// reason, like the application shutting down, are kept in the queue.
+var ErrCancelled = errors.New("job cancelled")
+
 type (
 	Job struct {
 		Ctx   context.Context
 		Track models.Track
 		Delay time.Duration
+		// ID of the play in the history, 0 if it isn't recorded
+		PlayID int64
 	}
 
 	Service struct {
-		jobChan         chan Job
-		scrobbleService *scrobble.Service
-		logger          zerolog.Logger
+		wake    chan struct{}
+		targets []scrobbler.Scrobbler
+		// Per target, a failing target is backed off
+		// without holding up the others
+		pausedUntil map[string]time.Time
+		queue       *queue.Repository
+		history     *history.Repository
+		clock       clock.Clock
+		logger      zerolog.Logger
 	}
 )
 
 func New(
-	scrobbleService *scrobble.Service,
+	queueRepo *queue.Repository,
+	historyRepo *history.Repository,
+	fanOut *scrobbler.FanOut,
+	clk clock.Clock,
 	logger zerolog.Logger,
 ) *Service {
 	return &Service{
-		scrobbleService: scrobbleService,
-		jobChan:         make(chan Job),
-		logger:          logger,
+		queue:       queueRepo,
+		history:     historyRepo,
+		clock:       clk,
+		targets:     fanOut.Targets(),
+		pausedUntil: make(map[string]time.Time, len(fanOut.Targets())),
+		wake:        make(chan struct{}, 1),
+		logger:      logger,
+	}
+}
+
+// DueAt returns when the job is to be sent, its delay after the track started playing.
+func (j Job) DueAt() time.Time {
+	return j.Track.Timestamp.Add(j.Delay)
+}
+
+// Add persists the job to the queue for every target, it will be sent once it's due,
+// unless its context is cancelled with ErrCancelled before that. A job that's due
+// already, e.g. of a play read from the log after the fact, is sent right away.
+func (s *Service) Add(job Job) error {
+	ids, err := s.queue.Add(
+		context.Background(),
+		job.PlayID,
+		job.Track,
+		job.DueAt(),
+		s.targetNames(),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range s.targetNames() {
+		s.recordOutcome(context.Background(), job.PlayID, history.Outcome{Target: target, Status: history.StatusPending})
+	}
+
+	wait := job.DueAt().Sub(s.clock.Now())
+	if wait <= 0 {
+		s.notify()
+		return nil
+	}
+
+	go func() {
+		select {
+		case <-s.clock.After(wait):
+			s.notify()
+		case <-job.Ctx.Done():
+			if !errors.Is(context.Cause(job.Ctx), ErrCancelled) {
+				return
+			}
+
+			if err := s.queue.Remove(context.Background(), ids...); err != nil {
+				s.logger.Error().Err(err).Msg("")
+			}
+
+			if job.PlayID == 0 {
+				return
+			}
+
+			if err := s.history.Skip(context.Background(), job.PlayID, history.ReasonNotPlayed); err != nil {
+				s.logger.Error().Err(err).Msg("")
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Enqueue records the plays in the history and persists them to the queue for every target,
+// to be sent right away by whichever process is working the queue. It's meant for plays that
+// already happened, which can't be cancelled. Plays the history already has sent or pending,
+// e.g. because they were scrobbled as they happened or enqueued before, are left out, others
+// it has, e.g. because they failed, are queued again. It returns how many plays were enqueued.
+func (s *Service) Enqueue(ctx context.Context, plays ...history.Play) (int, error) {
+	return s.EnqueueTo(ctx, s.targetNames(), plays...)
+}
+
+// EnqueueTo is Enqueue for some of the targets, which are expected to be targets of the service.
+// A play is only queued for the targets the history doesn't have it sent or pending for.
+func (s *Service) EnqueueTo(ctx context.Context, targets []string, plays ...history.Play) (int, error) {
+	if len(plays) == 0 {
+		return 0, nil
+	}
+
+	first := slices.MinFunc(plays, func(a, b history.Play) int {
+		return a.Track.Timestamp.Compare(b.Track.Timestamp)
+	})
+
+	last := slices.MaxFunc(plays, func(a, b history.Play) int {
+		return a.Track.Timestamp.Compare(b.Track.Timestamp)
+	})
+
+	recorded, err := s.history.Find(ctx, history.Filter{
+		Since: first.Track.Timestamp,
+		Until: last.Track.Timestamp.Add(time.Second),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	now := s.clock.Now()
+	enqueued := 0
+	for _, play := range plays {
+		matches := slices.DeleteFunc(slices.Clone(recorded), func(r history.Play) bool {
+			return !samePlay(r, play)
+		})
+
+		unsent := slices.DeleteFunc(slices.Clone(targets), func(target string) bool {
+			return slices.ContainsFunc(matches, func(r history.Play) bool {
+				return submitted(r, target)
+			})
+		})
+		if len(unsent) == 0 {
+			continue
+		}
+
+		id, err := s.record(ctx, matches, play)
+		if err != nil {
+			return enqueued, err
+		}
+
+		if _, err = s.queue.Add(ctx, id, play.Track, now, unsent); err != nil {
+			return enqueued, err
+		}
+
+		for _, target := range unsent {
+			s.recordOutcome(ctx, id, history.Outcome{Target: target, Status: history.StatusPending})
+		}
+
+		enqueued++
+	}
+
+	s.notify()
+
+	return enqueued, nil
+}
+
+// Requeue persists the recorded plays to the queue for the target again, to be sent right away,
+// whatever became of them before, e.g. they were sent but the service lost them.
+func (s *Service) Requeue(ctx context.Context, target string, plays ...history.Play) error {
+	now := s.clock.Now()
+	for _, play := range plays {
+		if _, err := s.queue.Add(ctx, play.ID, play.Track, now, []string{target}); err != nil {
+			return err
+		}
+
+		s.recordOutcome(ctx, play.ID, history.Outcome{Target: target, Status: history.StatusPending})
+	}
+
+	s.notify()
+
+	return nil
+}
+
+// Flush submits everything that's due once, for when Work isn't running.
+// Entries that couldn't be sent stay in the queue.
+func (s *Service) Flush(ctx context.Context) {
+	for _, target := range s.targets {
+		s.flush(ctx, target)
 	}
 }
 
-func (s *Service) Add(job Job) {
-	s.jobChan <- job
+func (s *Service) targetNames() []string {
+	names := make([]string, 0, len(s.targets))
+	for _, target := range s.targets {
+		names = append(names, target.Name())
+	}
+
+	return names
 }
 
 func (s *Service) Work(ctx context.Context) {
 	go func() {
+		// Flush immediately to pick up anything left over from a previous run
+		wait := time.Duration(0)
 		for {
 			select {
-			case job := <-s.jobChan:
-				s.sendWithDelay(job)
+			case <-s.wake:
+			case <-s.clock.After(wait):
 			case <-ctx.Done():
 				s.logger.Info().Msg("closing")
 				return
 			}
+
+			s.Flush(ctx)
+			wait = s.nextWakeup(ctx)
 		}
 	}()
 }
 
-func (s *Service) sendWithDelay(job Job) {
-	t := time.After(job.Delay)
-	go func() {
-		select {
-		case <-t:
-			s.send(job)
-		case <-job.Ctx.Done():
-			return
+func (s *Service) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// A flush is already scheduled
+	}
+}
+
+func (s *Service) nextWakeup(ctx context.Context) time.Duration {
+	wait := pollInterval
+	for _, target := range s.targets {
+		if pause := s.pausedUntil[target.Name()].Sub(s.clock.Now()); pause > 0 {
+			wait = min(wait, pause)
+			continue
 		}
-	}()
+
+		dueAt, ok, err := s.queue.NextDue(ctx, target.Name())
+		if err != nil {
+			s.logger.Error().Err(err).Msg("")
+			continue
+		}
+
+		if ok {
+			wait = min(wait, max(dueAt.Sub(s.clock.Now()), 0))
+		}
+	}
+
+	return wait
 }
 
-func (s *Service) send(job Job) {
-	scrobbles, err := s.scrobbleService.Scrobble(job.Ctx, job.Track)
-	if err != nil {
-		s.logger.
-			Error().
-			Err(err).
-			Msg("")
+func (s *Service) flush(ctx context.Context, target scrobbler.Scrobbler) {
+	if s.clock.Now().Before(s.pausedUntil[target.Name()]) {
+		// Backing off after the target was unreachable
+		return
+	}
 
-		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-			s.logger.
-				Info().
-				Str("artist", job.Track.Artist).
-				Str("track", job.Track.Name).
-				Msg("not scrobbling a cancelled job")
+	for {
+		entries, err := s.queue.Due(ctx, target.Name(), s.clock.Now(), batchSize)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("")
+			return
+		}
 
+		if len(entries) == 0 {
 			return
 		}
 
-		if errors.Is(err, &url.Error{}) {
-			// Everything should be retried with no delay in case of a network error
-			v := err.(*url.Error)
-			if v.Timeout() {
-				s.jobChan <- Job{
-					Ctx:   job.Ctx,
-					Delay: time.Duration(0),
-					Track: job.Track,
-				}
-			}
+		if !s.send(ctx, target, entries) {
+			return
+		}
 
+		if len(entries) < batchSize {
 			return
 		}
+	}
+}
+
+// send submits the queue entries and records the outcome.
+// A backlog of entries is submitted as a single batch.
+// It returns false if flushing should stop, because every other
+// entry is bound to fail the same way.
+func (s *Service) send(ctx context.Context, target scrobbler.Scrobbler, entries []queue.Entry) bool {
+	logger := s.logger.With().Str("target", target.Name()).Logger()
+
+	tracks := make([]models.Track, 0, len(entries))
+	for _, entry := range entries {
+		tracks = append(tracks, entry.Track)
+	}
+
+	results, err := target.Submit(ctx, tracks)
+	if err != nil {
+		return s.handleError(ctx, target, entries, err)
+	}
+
+	for i, entry := range entries {
+		if i >= len(results) {
+			// Shouldn't happen, but don't lose the entry if it does
+			logger.
+				Warn().
+				Str("artist", entry.Track.Artist).
+				Str("track", entry.Track.Name).
+				Msg("no result for scrobble, it will be retried")
+
+			if err = s.queue.Retry(ctx, entry.ID, s.clock.Now().Add(minRetryDelay), "missing result"); err != nil {
+				logger.Error().Err(err).Msg("")
+			}
+
+			s.recordOutcome(ctx, entry.PlayID, history.Outcome{
+				Target: entry.Target,
+				Status: history.StatusPending,
+				Reason: "missing result",
+			})
+
+			continue
+		}
 
-		if errors.Is(err, scrobble.ErrorResponse{}) {
-			v := err.(scrobble.ErrorResponse)
-			switch v.Code {
-			case scrobble.CodeServiceOffline, scrobble.CodeServiceTemporaryUnavailable:
-				// Only these codes indicate that the scrobble should be retried
-				s.jobChan <- Job{
-					Ctx:   job.Ctx,
-					Delay: time.Duration(0),
-					Track: job.Track,
-				}
-			case scrobble.CodeInvalidSessionKey:
-				// This indicates that the session with last.fm has been revoked
-				// and that the user should re-authenticate. This will not be
-				// handled for the user, so we'll just terminate the process here.
-				s.logger.
-					Error().
-					Msg("last.fm session invalid, re-authentication required, terminating")
-
-				// Gracefully exit the program by sending a
-				// signal it's programmed to intercept.
-				syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+		result := results[i]
+		if result.Ignored {
+			if err = s.queue.MarkIgnored(ctx, entry.ID, result.IgnoredReason); err != nil {
+				logger.Error().Err(err).Msg("")
 			}
+
+			s.recordOutcome(ctx, entry.PlayID, history.Outcome{
+				Target: entry.Target,
+				Status: history.StatusIgnored,
+				Reason: result.IgnoredReason,
+			})
+
+			logger.
+				Info().
+				Str("artist", entry.Track.Artist).
+				Str("track", entry.Track.Name).
+				Str("ignored_for", result.IgnoredReason).
+				Msg("scrobble ignored")
+
+			continue
 		}
 
-		return
+		if err = s.queue.MarkSent(ctx, entry.ID); err != nil {
+			logger.Error().Err(err).Msg("")
+		}
+
+		s.recordOutcome(ctx, entry.PlayID, history.Outcome{
+			Target:    entry.Target,
+			Status:    history.StatusSent,
+			Corrected: history.Corrections(entry.Track, result.Track),
+		})
+
+		logger.
+			Info().
+			Str("artist", result.Track.Artist).
+			Str("track", result.Track.Name).
+			Msg("successful scrobble")
+	}
+
+	return true
+}
+
+func (s *Service) handleError(
+	ctx context.Context,
+	target scrobbler.Scrobbler,
+	entries []queue.Entry,
+	err error,
+) bool {
+	kind := target.Classify(err)
+	logger := s.logger.With().Str("target", target.Name()).Logger()
+	logger.
+		Error().
+		Err(err).
+		Stringer("kind", kind).
+		Int("entries", len(entries)).
+		Msg("")
+
+	switch kind {
+	case scrobbler.ErrorCancelled:
+		// Shutting down, the entries stay pending for the next run
+		return false
+	case scrobbler.ErrorTemporary:
+		// The target is unreachable, everything should be retried later
+		s.retry(ctx, target, entries, err)
+		return false
+	case scrobbler.ErrorAuth:
+		// The session was revoked and the user should re-authenticate,
+		// which will not be handled for the user. The entries stay pending
+		// and the target is periodically retried, in the meantime
+		// the other targets keep working.
+		s.pausedUntil[target.Name()] = s.clock.Now().Add(maxRetryDelay)
+		logger.
+			Error().
+			Msg("re-authentication required, submissions paused")
+
+		return false
+	}
+
+	// A single bad track can fail the whole batch, the halves are
+	// submitted separately so only the bad ones end up failed
+	if kind == scrobbler.ErrorInvalidTrack && len(entries) > 1 {
+		half := len(entries) / 2
+
+		return s.send(ctx, target, entries[:half]) && s.send(ctx, target, entries[half:])
+	}
+
+	for _, entry := range entries {
+		if err := s.queue.MarkFailed(ctx, entry.ID, err.Error()); err != nil {
+			logger.Error().Err(err).Msg("")
+		}
+
+		s.recordOutcome(ctx, entry.PlayID, history.Outcome{
+			Target: entry.Target,
+			Status: history.StatusFailed,
+			Reason: err.Error(),
+		})
+	}
+
+	return true
+}
+
+func (s *Service) retry(
+	ctx context.Context,
+	target scrobbler.Scrobbler,
+	entries []queue.Entry,
+	reason error,
+) {
+	attempts := 0
+	for _, entry := range entries {
+		attempts = max(attempts, entry.Attempts)
+	}
+
+	delay := min(minRetryDelay<<min(attempts, 10), maxRetryDelay)
+	s.pausedUntil[target.Name()] = s.clock.Now().Add(delay)
+	for _, entry := range entries {
+		if err := s.queue.Retry(ctx, entry.ID, s.pausedUntil[target.Name()], reason.Error()); err != nil {
+			s.logger.Error().Err(err).Msg("")
+		}
+
+		s.recordOutcome(ctx, entry.PlayID, history.Outcome{
+			Target: entry.Target,
+			Status: history.StatusPending,
+			Reason: reason.Error(),
+		})
 	}
 
 	s.logger.
 		Info().
-		Str("artist", scrobbles.Scrobbles.Scrobble.Artist.Text).
-		Str("track", scrobbles.Scrobbles.Scrobble.Track.Text).
-		Int("accepted", scrobbles.Scrobbles.Attr.Accepted).
-		Int("ignored", scrobbles.Scrobbles.Attr.Ignored).
-		Msg("successful scrobble")
+		Str("target", target.Name()).
+		Int("entries", len(entries)).
+		Dur("retry_in", delay).
+		Msg("scrobbles postponed")
+}
+
+// record returns the ID of the play in the history, the first of those recorded
+// as the same play if there are any, it's added otherwise.
+func (s *Service) record(ctx context.Context, matches []history.Play, play history.Play) (int64, error) {
+	if len(matches) == 0 {
+		return s.history.Add(ctx, play)
+	}
+
+	// Submitted after all, e.g. it was cut short according to the log the watcher read
+	if matches[0].Skipped != "" {
+		if err := s.history.Unskip(ctx, matches[0].ID); err != nil {
+			return 0, err
+		}
+	}
+
+	return matches[0].ID, nil
+}
+
+// samePlay tells whether the plays are of the same track at the same second, ignoring case.
+func samePlay(a, b history.Play) bool {
+	return strings.EqualFold(a.Track.Artist, b.Track.Artist) &&
+		strings.EqualFold(a.Track.Name, b.Track.Name) &&
+		a.Track.Timestamp.Unix() == b.Track.Timestamp.Unix()
+}
+
+// submitted tells whether the play was sent to the target, or is waiting to be.
+func submitted(play history.Play, target string) bool {
+	return slices.ContainsFunc(play.Outcomes, func(o history.Outcome) bool {
+		return o.Target == target && (o.Status == history.StatusSent || o.Status == history.StatusPending)
+	})
+}
+
+// recordOutcome records what became of the play in the history, if it's recorded there.
+// The history is informational, failing to record it doesn't affect submissions.
+func (s *Service) recordOutcome(ctx context.Context, playID int64, outcome history.Outcome) {
+	if playID == 0 {
+		return
+	}
+
+	if err := s.history.SetOutcome(ctx, playID, outcome); err != nil {
+		s.logger.Error().Err(err).Msg("")
+	}
 }
@@ -0,0 +1,340 @@
+package job
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/clock"
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/queue"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobbler"
+	"github.com/rs/zerolog"
+
+	_ "github.com/glebarez/go-sqlite"
+)
+
+var (
+	errBadTrack = errors.New("invalid parameters")
+	errRequest  = errors.New("invalid method")
+)
+
+type (
+	// fakeTarget rejects every batch that contains the bad track, like
+	// a service that fails the whole request for a single invalid scrobble.
+	// If it has an error, it rejects every batch with it.
+	fakeTarget struct {
+		name    string
+		bad     string
+		err     error
+		batches [][]models.Track
+	}
+)
+
+func (f *fakeTarget) Name() string {
+	if f.name == "" {
+		return "fake"
+	}
+
+	return f.name
+}
+
+func (f *fakeTarget) NowPlaying(_ context.Context, track models.Track) (models.Submission, error) {
+	return models.Submission{Track: track}, nil
+}
+
+func (f *fakeTarget) Submit(_ context.Context, tracks []models.Track) ([]models.Submission, error) {
+	f.batches = append(f.batches, tracks)
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	if slices.ContainsFunc(tracks, func(track models.Track) bool { return track.Name == f.bad }) {
+		return nil, errBadTrack
+	}
+
+	submissions := make([]models.Submission, 0, len(tracks))
+	for _, track := range tracks {
+		submissions = append(submissions, models.Submission{Track: track})
+	}
+
+	return submissions, nil
+}
+
+func (f *fakeTarget) Classify(err error) scrobbler.ErrorKind {
+	if errors.Is(err, errBadTrack) {
+		return scrobbler.ErrorInvalidTrack
+	}
+
+	return scrobbler.ErrorPermanent
+}
+
+// newService returns a service submitting to the targets, with empty repositories.
+func newService(t *testing.T, targets ...*fakeTarget) (*Service, *queue.Repository, *history.Repository, *clock.Fake) {
+	t.Helper()
+
+	dir := t.TempDir()
+	queueDB, err := sql.Open("sqlite", filepath.Join(dir, "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queueRepo, err := queue.New(queueDB, targets[0].Name(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { queueRepo.Close() })
+
+	historyDB, err := sql.Open("sqlite", filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	historyRepo, err := history.New(historyDB, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { historyRepo.Close() })
+
+	clk := clock.NewFake(time.Date(2025, time.March, 1, 20, 0, 0, 0, time.Local))
+	fanOut := make([]scrobbler.Scrobbler, 0, len(targets))
+	for _, target := range targets {
+		fanOut = append(fanOut, target)
+	}
+
+	s := New(queueRepo, historyRepo, scrobbler.NewFanOut(fanOut...), clk, zerolog.Nop())
+
+	return s, queueRepo, historyRepo, clk
+}
+
+// addBacklog records and queues the number of plays for the target, due now.
+func addBacklog(
+	t *testing.T,
+	queueRepo *queue.Repository,
+	historyRepo *history.Repository,
+	clk *clock.Fake,
+	target *fakeTarget,
+	plays int,
+) {
+	t.Helper()
+
+	ctx := context.Background()
+	for i := range plays {
+		track := models.Track{
+			Artist:    "Artist",
+			Name:      fmt.Sprintf("Track %d", i),
+			Timestamp: clk.Now().Add(time.Duration(i-plays) * time.Minute * 4),
+		}
+
+		id, err := historyRepo.Add(ctx, history.Play{Track: track})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = queueRepo.Add(ctx, id, track, clk.Now(), []string{target.Name()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFlushBadTrackInBatch(t *testing.T) {
+	target := &fakeTarget{bad: "Track 5"}
+	s, queueRepo, historyRepo, clk := newService(t, target)
+	ctx := context.Background()
+	addBacklog(t, queueRepo, historyRepo, clk, target, 8)
+
+	s.Flush(ctx)
+
+	if len(target.batches[0]) != 8 {
+		t.Errorf("expected the backlog to be submitted as a single batch first, got %d tracks", len(target.batches[0]))
+	}
+
+	plays, err := historyRepo.Find(ctx, history.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, play := range plays {
+		expected := history.StatusSent
+		if play.Track.Name == target.bad {
+			expected = history.StatusFailed
+		}
+
+		if len(play.Outcomes) != 1 || play.Outcomes[0].Status != expected {
+			t.Errorf("%s: expected a single %s outcome, got %+v", play.Track.Name, expected, play.Outcomes)
+		}
+	}
+
+	if _, ok, err := queueRepo.NextDue(ctx, target.Name()); err != nil || ok {
+		t.Errorf("expected nothing left pending, got %v, %v", ok, err)
+	}
+}
+
+func TestFlushRequestError(t *testing.T) {
+	target := &fakeTarget{err: errRequest}
+	s, queueRepo, historyRepo, clk := newService(t, target)
+	ctx := context.Background()
+	addBacklog(t, queueRepo, historyRepo, clk, target, 8)
+
+	s.Flush(ctx)
+
+	// Not caused by any of the tracks, so splitting the batch wouldn't help
+	if len(target.batches) != 1 {
+		t.Errorf("expected a single request, got %d", len(target.batches))
+	}
+
+	plays, err := historyRepo.Find(ctx, history.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, play := range plays {
+		if len(play.Outcomes) != 1 || play.Outcomes[0].Status != history.StatusFailed {
+			t.Errorf("%s: expected a single failed outcome, got %+v", play.Track.Name, play.Outcomes)
+		}
+	}
+}
+
+func TestAddDueFromPlayTime(t *testing.T) {
+	target := &fakeTarget{}
+	s, queueRepo, _, clk := newService(t, target)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Read from the log after the fact, the play was due a while ago
+	track := models.Track{Artist: "Artist", Name: "Track", Timestamp: clk.Now().Add(-time.Minute * 5)}
+	if err := s.Add(Job{Ctx: ctx, Track: track, Delay: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(clk.Pending()) != 0 {
+		t.Errorf("expected no timer for a job that's due, got %v", clk.Pending())
+	}
+
+	dueAt, ok, err := queueRepo.NextDue(ctx, target.Name())
+	if err != nil || !ok || !dueAt.Equal(track.Timestamp.Add(time.Minute)) {
+		t.Errorf("expected the entry to be due a minute after the play started, got %v, %v, %v", dueAt, ok, err)
+	}
+
+	select {
+	case <-s.wake:
+	default:
+		t.Error("expected the job to be sent right away")
+	}
+}
+
+func TestEnqueueRequeuesFailedPlay(t *testing.T) {
+	target := &fakeTarget{}
+	s, _, historyRepo, clk := newService(t, target)
+	ctx := context.Background()
+
+	play := history.Play{Track: models.Track{Artist: "Artist", Name: "Track", Timestamp: clk.Now().Add(-time.Hour)}}
+	id, err := historyRepo.Add(ctx, play)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = historyRepo.SetOutcome(ctx, id, history.Outcome{Target: target.Name(), Status: history.StatusFailed})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []int{1, 0} {
+		enqueued, err := s.Enqueue(ctx, play)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if enqueued != expected {
+			t.Errorf("expected %d enqueued, got %d", expected, enqueued)
+		}
+	}
+
+	plays, err := historyRepo.Find(ctx, history.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plays) != 1 || plays[0].ID != id || plays[0].Outcomes[0].Status != history.StatusPending {
+		t.Errorf("expected the recorded play to be pending again, got %+v", plays)
+	}
+}
+
+func TestEnqueueToTarget(t *testing.T) {
+	sent, other := &fakeTarget{name: "sent"}, &fakeTarget{name: "other"}
+	s, queueRepo, historyRepo, clk := newService(t, sent, other)
+	ctx := context.Background()
+
+	play := history.Play{Track: models.Track{Artist: "Artist", Name: "Track", Timestamp: clk.Now().Add(-time.Hour)}}
+	id, err := historyRepo.Add(ctx, play)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = historyRepo.SetOutcome(ctx, id, history.Outcome{Target: sent.Name(), Status: history.StatusSent}); err != nil {
+		t.Fatal(err)
+	}
+
+	if enqueued, err := s.EnqueueTo(ctx, []string{sent.Name()}, play); err != nil || enqueued != 0 {
+		t.Errorf("expected the play sent to the target to be left out, got %d, %v", enqueued, err)
+	}
+
+	// Only queued for the target it wasn't sent to
+	if enqueued, err := s.Enqueue(ctx, play); err != nil || enqueued != 1 {
+		t.Errorf("expected the play to be enqueued, got %d, %v", enqueued, err)
+	}
+
+	for target, expected := range map[string]bool{sent.Name(): false, other.Name(): true} {
+		if _, ok, err := queueRepo.NextDue(ctx, target); err != nil || ok != expected {
+			t.Errorf("%s: expected pending %v, got %v, %v", target, expected, ok, err)
+		}
+	}
+}
+
+func TestRequeueSentPlay(t *testing.T) {
+	target := &fakeTarget{}
+	s, queueRepo, historyRepo, clk := newService(t, target)
+	ctx := context.Background()
+
+	play := history.Play{Track: models.Track{Artist: "Artist", Name: "Track", Timestamp: clk.Now().Add(-time.Hour)}}
+	id, err := historyRepo.Add(ctx, play)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = historyRepo.SetOutcome(ctx, id, history.Outcome{Target: target.Name(), Status: history.StatusSent}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sent, but missing from the service
+	play.ID = id
+	if err = s.Requeue(ctx, target.Name(), play); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := queueRepo.Due(ctx, target.Name(), clk.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].PlayID != id {
+		t.Errorf("expected the play to be queued again, got %+v", entries)
+	}
+
+	plays, err := historyRepo.Find(ctx, history.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plays) != 1 || plays[0].Outcomes[0].Status != history.StatusPending {
+		t.Errorf("expected the play to be pending, got %+v", plays)
+	}
+}
@@ -0,0 +1,236 @@
+package listenbrainz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/config"
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobbler"
+)
+
+const (
+	ListenTypePlayingNow = "playing_now"
+	ListenTypeSingle     = "single"
+	ListenTypeImport     = "import"
+
+	// MaxBatchSize is the maximum number of listens accepted in a single request
+	MaxBatchSize = 1000
+
+	submitPath = "/1/submit-listens"
+)
+
+type (
+	Service struct {
+		cfg    config.ListenBrainz
+		client *http.Client
+	}
+
+	AdditionalInfo struct {
+		DurationMS       int64  `json:"duration_ms,omitempty"`
+		TrackNumber      int    `json:"tracknumber,omitempty"`
+		SubmissionClient string `json:"submission_client"`
+	}
+
+	TrackMetadata struct {
+		ArtistName     string         `json:"artist_name"`
+		TrackName      string         `json:"track_name"`
+		ReleaseName    string         `json:"release_name,omitempty"`
+		AdditionalInfo AdditionalInfo `json:"additional_info"`
+	}
+
+	Listen struct {
+		ListenedAt    int64         `json:"listened_at,omitempty"`
+		TrackMetadata TrackMetadata `json:"track_metadata"`
+	}
+
+	SubmitRequest struct {
+		ListenType string   `json:"listen_type"`
+		Payload    []Listen `json:"payload"`
+	}
+
+	SubmitResponse struct {
+		Status string `json:"status"`
+	}
+
+	ErrorResponse struct {
+		Message string `json:"error"`
+		Code    int    `json:"code"`
+	}
+)
+
+var ErrBatchSize = fmt.Errorf("a batch must contain between 1 and %d tracks", MaxBatchSize)
+
+func (er ErrorResponse) Error() string {
+	return fmt.Sprintf("request failed with: message - %s, code - %d", er.Message, er.Code)
+}
+
+func New(
+	cfg config.ListenBrainz,
+) *Service {
+	return &Service{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+}
+
+func (s *Service) Name() string {
+	return constants.BackendListenBrainz
+}
+
+func (s *Service) Classify(err error) scrobbler.ErrorKind {
+	if kind, ok := scrobbler.ClassifyTransport(err); ok {
+		return kind
+	}
+
+	var errResp ErrorResponse
+	if !errors.As(err, &errResp) {
+		return scrobbler.ErrorPermanent
+	}
+
+	switch {
+	case errResp.Code == http.StatusTooManyRequests, errResp.Code >= http.StatusInternalServerError:
+		return scrobbler.ErrorTemporary
+	case errResp.Code == http.StatusUnauthorized:
+		// The token was revoked or regenerated
+		return scrobbler.ErrorAuth
+	case errResp.Code == http.StatusBadRequest:
+		// Listens are validated one by one, any of them can be invalid
+		return scrobbler.ErrorInvalidTrack
+	default:
+		return scrobbler.ErrorPermanent
+	}
+}
+
+func ToListen(t models.Track, withTimestamp bool) Listen {
+	listen := Listen{
+		TrackMetadata: TrackMetadata{
+			ArtistName:  t.Artist,
+			TrackName:   t.Name,
+			ReleaseName: t.Album,
+			AdditionalInfo: AdditionalInfo{
+				DurationMS:       t.Duration.Milliseconds(),
+				TrackNumber:      t.Number,
+				SubmissionClient: "minidlna-scrobble",
+			},
+		},
+	}
+
+	if withTimestamp {
+		listen.ListenedAt = t.Timestamp.UTC().Unix()
+	}
+
+	return listen
+}
+
+// NowPlaying reports the track as currently playing.
+// ListenBrainz doesn't ignore or correct listens, so the submission
+// always reflects the track that was sent.
+func (s *Service) NowPlaying(ctx context.Context, track models.Track) (models.Submission, error) {
+	err := s.submit(ctx, SubmitRequest{
+		ListenType: ListenTypePlayingNow,
+		Payload:    []Listen{ToListen(track, false)},
+	})
+	if err != nil {
+		return models.Submission{}, err
+	}
+
+	return models.Submission{Track: track}, nil
+}
+
+// Submit records the tracks as listens, multiple tracks are sent as a single import.
+func (s *Service) Submit(ctx context.Context, tracks []models.Track) ([]models.Submission, error) {
+	if len(tracks) == 0 || len(tracks) > MaxBatchSize {
+		return nil, ErrBatchSize
+	}
+
+	req := SubmitRequest{
+		ListenType: ListenTypeSingle,
+		Payload:    make([]Listen, 0, len(tracks)),
+	}
+
+	if len(tracks) > 1 {
+		req.ListenType = ListenTypeImport
+	}
+
+	submissions := make([]models.Submission, 0, len(tracks))
+	for _, track := range tracks {
+		req.Payload = append(req.Payload, ToListen(track, true))
+		submissions = append(submissions, models.Submission{Track: track})
+	}
+
+	if err := s.submit(ctx, req); err != nil {
+		return nil, err
+	}
+
+	return submissions, nil
+}
+
+func (s *Service) submit(ctx context.Context, data SubmitRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		strings.TrimSuffix(s.cfg.APIURL, "/")+submitPath,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Add("Content-Type", "application/json")
+	request.Header.Add("Authorization", "Token "+s.cfg.Token)
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	buff, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		errResp := ErrorResponse{Code: response.StatusCode}
+		if err := json.Unmarshal(buff, &errResp); err != nil {
+			// Proxies in front of the API don't necessarily respond with JSON
+			errResp.Message = http.StatusText(response.StatusCode)
+		}
+
+		return errResp
+	}
+
+	var submitResp SubmitResponse
+	if err = json.Unmarshal(buff, &submitResp); err != nil {
+		return err
+	}
+
+	if submitResp.Status != "ok" {
+		return ErrorResponse{
+			Message: "unexpected status " + submitResp.Status,
+			Code:    response.StatusCode,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package listenbrainz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/config"
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobbler"
+)
+
+const token = "test-token"
+
+var tracks = []models.Track{
+	{
+		Artist:    "Boards of Canada",
+		Name:      "Roygbiv",
+		Album:     "Music Has the Right to Children",
+		Duration:  time.Millisecond * 151500,
+		Number:    10,
+		Timestamp: time.Date(2025, time.March, 1, 20, 0, 0, 0, time.UTC),
+	},
+	{
+		Artist:    "Aphex Twin",
+		Name:      "Xtal",
+		Timestamp: time.Date(2025, time.March, 1, 20, 5, 0, 0, time.UTC),
+	},
+}
+
+// newServer returns a service submitting to a fake API, which records
+// the requests it receives and responds with the status and body.
+func newServer(t *testing.T, status int, body string) (*Service, *[]SubmitRequest) {
+	t.Helper()
+
+	requests := make([]SubmitRequest, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != submitPath {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		if auth := r.Header.Get("Authorization"); auth != "Token "+token {
+			t.Errorf("expected the token in the Authorization header, got %q", auth)
+		}
+
+		var req SubmitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+
+		requests = append(requests, req)
+
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+
+	t.Cleanup(server.Close)
+
+	return New(config.ListenBrainz{Token: token, APIURL: server.URL + "/"}), &requests
+}
+
+func TestNowPlaying(t *testing.T) {
+	s, requests := newServer(t, http.StatusOK, `{"status":"ok"}`)
+
+	submission, err := s.NowPlaying(context.Background(), tracks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if submission.Track != tracks[0] || submission.Ignored {
+		t.Errorf("unexpected submission %+v", submission)
+	}
+
+	expected := []SubmitRequest{{
+		ListenType: ListenTypePlayingNow,
+		Payload: []Listen{{
+			TrackMetadata: TrackMetadata{
+				ArtistName:  "Boards of Canada",
+				TrackName:   "Roygbiv",
+				ReleaseName: "Music Has the Right to Children",
+				AdditionalInfo: AdditionalInfo{
+					DurationMS:       151500,
+					TrackNumber:      10,
+					SubmissionClient: "minidlna-scrobble",
+				},
+			},
+		}},
+	}}
+
+	if !reflect.DeepEqual(*requests, expected) {
+		t.Errorf("expected requests %+v, got %+v", expected, *requests)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	tests := []struct {
+		name       string
+		tracks     []models.Track
+		listenType string
+	}{
+		{name: "single", tracks: tracks[:1], listenType: ListenTypeSingle},
+		{name: "import", tracks: tracks, listenType: ListenTypeImport},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, requests := newServer(t, http.StatusOK, `{"status":"ok"}`)
+
+			submissions, err := s.Submit(context.Background(), tt.tracks)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(submissions) != len(tt.tracks) {
+				t.Fatalf("expected %d submissions, got %d", len(tt.tracks), len(submissions))
+			}
+
+			if len(*requests) != 1 {
+				t.Fatalf("expected a single request, got %d", len(*requests))
+			}
+
+			req := (*requests)[0]
+			if req.ListenType != tt.listenType {
+				t.Errorf("expected listen type %s, got %s", tt.listenType, req.ListenType)
+			}
+
+			for i, track := range tt.tracks {
+				if submissions[i].Track != track {
+					t.Errorf("unexpected submission %+v", submissions[i])
+				}
+
+				if req.Payload[i].ListenedAt != track.Timestamp.Unix() || req.Payload[i].TrackMetadata.TrackName != track.Name {
+					t.Errorf("unexpected listen %+v", req.Payload[i])
+				}
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		kind   scrobbler.ErrorKind
+	}{
+		{status: http.StatusUnauthorized, body: `{"code":401,"error":"Invalid authorization token."}`, kind: scrobbler.ErrorAuth},
+		{status: http.StatusTooManyRequests, body: `{"code":429,"error":"Too many requests"}`, kind: scrobbler.ErrorTemporary},
+		{status: http.StatusBadGateway, body: "<html>Bad Gateway</html>", kind: scrobbler.ErrorTemporary},
+		{status: http.StatusServiceUnavailable, body: `{"code":503,"error":"Service unavailable"}`, kind: scrobbler.ErrorTemporary},
+		{status: http.StatusBadRequest, body: `{"code":400,"error":"Invalid listen"}`, kind: scrobbler.ErrorInvalidTrack},
+		{status: http.StatusNotFound, body: `{"code":404,"error":"Not found"}`, kind: scrobbler.ErrorPermanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			s, _ := newServer(t, tt.status, tt.body)
+
+			_, err := s.Submit(context.Background(), tracks[:1])
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if kind := s.Classify(err); kind != tt.kind {
+				t.Errorf("expected %s, got %s for %v", tt.kind, kind, err)
+			}
+		})
+	}
+}
@@ -1,25 +1,36 @@
 package scrobble
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/dusnm/minidlna-scrobble/pkg/config"
-	"github.com/dusnm/minidlna-scrobble/pkg/constants"
 	"github.com/dusnm/minidlna-scrobble/pkg/helpers"
 	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobbler"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/sessioncache"
 )
 
 const (
+	// MaxBatchSize is the maximum number of scrobbles last.fm accepts in a single request
+	MaxBatchSize = 50
+	// RecentTracksPageSize is the maximum number of tracks last.fm returns per page of recent tracks
+	RecentTracksPageSize = 200
+
+	CodeInvalidParameters           = 6
 	CodeInvalidSessionKey           = 9
 	CodeServiceOffline              = 11
 	CodeServiceTemporaryUnavailable = 16
+	CodeRateLimitExceeded           = 29
 )
 
 type (
@@ -54,28 +65,158 @@ type (
 		} `json:"nowplaying"`
 	}
 
+	ScrobbleResult struct {
+		IgnoredMessage Ignored              `json:"ignoredMessage"`
+		Artist         struct{ Correction } `json:"artist"`
+		Track          struct{ Correction } `json:"track"`
+		AlbumArtist    struct{ Correction } `json:"albumArtist"`
+		Album          struct{ Correction } `json:"album"`
+		Timestamp      string               `json:"timestamp"`
+	}
+
+	// ScrobbleResults handles last.fm returning a single
+	// object instead of an array when there's only one result.
+	ScrobbleResults []ScrobbleResult
+
+	ScrobbleAttr struct {
+		Ignored  int `json:"ignored"`
+		Accepted int `json:"accepted"`
+	}
+
 	ScrobbleResponse struct {
 		Scrobbles struct {
-			Scrobble struct {
-				IgnoredMessage Ignored              `json:"ignoredMessage"`
-				Artist         struct{ Correction } `json:"artist"`
-				Track          struct{ Correction } `json:"track"`
-				AlbumArtist    struct{ Correction } `json:"albumArtist"`
-				Album          struct{ Correction } `json:"album"`
-				Timestamp      string               `json:"timestamp"`
-			} `json:"scrobble"`
-			Attr struct {
-				Ignored  int `json:"ignored"`
-				Accepted int `json:"accepted"`
-			} `json:"@attr"`
+			Scrobble ScrobbleResult `json:"scrobble"`
+			Attr     ScrobbleAttr   `json:"@attr"`
 		} `json:"scrobbles"`
 	}
+
+	BatchScrobbleResponse struct {
+		Scrobbles struct {
+			Scrobble ScrobbleResults `json:"scrobble"`
+			Attr     ScrobbleAttr    `json:"@attr"`
+		} `json:"scrobbles"`
+	}
+
+	Text struct {
+		Text string `json:"#text"`
+	}
+
+	RecentTrack struct {
+		Artist Text   `json:"artist"`
+		Name   string `json:"name"`
+		Album  Text   `json:"album"`
+		// Missing for the track that's playing now
+		Date struct {
+			UTS string `json:"uts"`
+		} `json:"date"`
+	}
+
+	// RecentTrackList handles last.fm returning a single
+	// object instead of an array when there's only one track.
+	RecentTrackList []RecentTrack
+
+	// The numbers are strings in the responses of last.fm
+	RecentTracksAttr struct {
+		Page       string `json:"page"`
+		TotalPages string `json:"totalPages"`
+	}
+
+	RecentTracksResponse struct {
+		RecentTracks struct {
+			Track RecentTrackList  `json:"track"`
+			Attr  RecentTracksAttr `json:"@attr"`
+		} `json:"recenttracks"`
+	}
 )
 
+var ErrBatchSize = fmt.Errorf("a batch must contain between 1 and %d tracks", MaxBatchSize)
+
 func (er ErrorResponse) Error() string {
 	return fmt.Sprintf("request failed with: message - %s, code - %d", er.Message, er.Code)
 }
 
+// Ignored reports whether last.fm refused to record the scrobble.
+func (sr ScrobbleResult) Ignored() bool {
+	return sr.IgnoredMessage.Code != "" && sr.IgnoredMessage.Code != "0"
+}
+
+// ToSubmission applies the corrections last.fm made on top of the submitted track.
+func (sr ScrobbleResult) ToSubmission(track models.Track) models.Submission {
+	return toSubmission(
+		track,
+		sr.IgnoredMessage,
+		sr.Artist.Text,
+		sr.Track.Text,
+		sr.Album.Text,
+	)
+}
+
+func toSubmission(track models.Track, ignored Ignored, artist, name, album string) models.Submission {
+	if artist != "" {
+		track.Artist = artist
+	}
+
+	if name != "" {
+		track.Name = name
+	}
+
+	if album != "" {
+		track.Album = album
+	}
+
+	return models.Submission{
+		Track:         track,
+		Ignored:       ignored.Code != "" && ignored.Code != "0",
+		IgnoredReason: ignored.Text,
+	}
+}
+
+func (sr *ScrobbleResults) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var single ScrobbleResult
+		if err := json.Unmarshal(data, &single); err != nil {
+			return err
+		}
+
+		*sr = ScrobbleResults{single}
+
+		return nil
+	}
+
+	var many []ScrobbleResult
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+
+	*sr = many
+
+	return nil
+}
+
+func (rt *RecentTrackList) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var single RecentTrack
+		if err := json.Unmarshal(data, &single); err != nil {
+			return err
+		}
+
+		*rt = RecentTrackList{single}
+
+		return nil
+	}
+
+	var many []RecentTrack
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+
+	*rt = many
+
+	return nil
+}
+
 func New(
 	cfg config.Credentials,
 	sessionCache *sessioncache.Service,
@@ -89,6 +230,32 @@ func New(
 	}
 }
 
+func (s *Service) Name() string {
+	return s.cfg.Name
+}
+
+func (s *Service) Classify(err error) scrobbler.ErrorKind {
+	if kind, ok := scrobbler.ClassifyTransport(err); ok {
+		return kind
+	}
+
+	var errResp ErrorResponse
+	if !errors.As(err, &errResp) {
+		return scrobbler.ErrorPermanent
+	}
+
+	switch errResp.Code {
+	case CodeServiceOffline, CodeServiceTemporaryUnavailable, CodeRateLimitExceeded:
+		return scrobbler.ErrorTemporary
+	case CodeInvalidSessionKey:
+		return scrobbler.ErrorAuth
+	case CodeInvalidParameters:
+		return scrobbler.ErrorInvalidTrack
+	default:
+		return scrobbler.ErrorPermanent
+	}
+}
+
 func (s *Service) SendNowPlaying(
 	ctx context.Context,
 	data models.Track,
@@ -112,7 +279,7 @@ func (s *Service) SendNowPlaying(
 	request, err := http.NewRequestWithContext(
 		ctx,
 		http.MethodPost,
-		constants.APIBaseURL,
+		s.cfg.APIURL,
 		strings.NewReader(form.Encode()),
 	)
 	if err != nil {
@@ -169,7 +336,7 @@ func (s *Service) Scrobble(ctx context.Context, data models.Track) (ScrobbleResp
 	request, err := http.NewRequestWithContext(
 		ctx,
 		http.MethodPost,
-		constants.APIBaseURL,
+		s.cfg.APIURL,
 		strings.NewReader(form.Encode()),
 	)
 	if err != nil {
@@ -205,3 +372,233 @@ func (s *Service) Scrobble(ctx context.Context, data models.Track) (ScrobbleResp
 
 	return scrobbleResponse, nil
 }
+
+// ScrobbleBatch submits up to MaxBatchSize tracks in a single request.
+// The results are in the same order as the submitted tracks.
+func (s *Service) ScrobbleBatch(ctx context.Context, tracks []models.Track) (BatchScrobbleResponse, error) {
+	if len(tracks) == 0 || len(tracks) > MaxBatchSize {
+		return BatchScrobbleResponse{}, ErrBatchSize
+	}
+
+	if err := ctx.Err(); err != nil {
+		return BatchScrobbleResponse{}, err
+	}
+
+	session, err := s.sessionCache.Read()
+	if err != nil {
+		return BatchScrobbleResponse{}, err
+	}
+
+	form := url.Values{}
+	for i, track := range tracks {
+		for key, values := range track.ToForm() {
+			for _, value := range values {
+				form.Add(fmt.Sprintf("%s[%d]", key, i), value)
+			}
+		}
+	}
+
+	form.Add("format", "json")
+	form.Add("method", "track.scrobble")
+	form.Add("api_key", s.cfg.APIKey)
+	form.Add("sk", session.Session.Key)
+	form.Add("api_sig", helpers.CalculateSignature(form, s.cfg.SharedSecret))
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		s.cfg.APIURL,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return BatchScrobbleResponse{}, err
+	}
+
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	response, err := s.client.Do(request)
+	if err != nil {
+		return BatchScrobbleResponse{}, err
+	}
+
+	defer response.Body.Close()
+
+	buff, err := io.ReadAll(response.Body)
+	if err != nil {
+		return BatchScrobbleResponse{}, err
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		var errResp ErrorResponse
+		if err := json.Unmarshal(buff, &errResp); err != nil {
+			return BatchScrobbleResponse{}, err
+		}
+
+		return BatchScrobbleResponse{}, errResp
+	}
+
+	var batchResponse BatchScrobbleResponse
+	if err = json.Unmarshal(buff, &batchResponse); err != nil {
+		return BatchScrobbleResponse{}, err
+	}
+
+	return batchResponse, nil
+}
+
+// NowPlaying is SendNowPlaying, with the response translated into a submission.
+func (s *Service) NowPlaying(ctx context.Context, track models.Track) (models.Submission, error) {
+	npResp, err := s.SendNowPlaying(ctx, track)
+	if err != nil {
+		return models.Submission{}, err
+	}
+
+	np := npResp.NowPlaying
+
+	return toSubmission(
+		track,
+		np.IgnoredMessage,
+		np.Artist.Text,
+		np.Track.Text,
+		np.Album.Text,
+	), nil
+}
+
+// Submit scrobbles the tracks, using a single batch request for more than one track.
+// The submissions are in the same order as the tracks.
+func (s *Service) Submit(ctx context.Context, tracks []models.Track) ([]models.Submission, error) {
+	var results ScrobbleResults
+	switch len(tracks) {
+	case 0:
+		return nil, ErrBatchSize
+	case 1:
+		resp, err := s.Scrobble(ctx, tracks[0])
+		if err != nil {
+			return nil, err
+		}
+
+		results = ScrobbleResults{resp.Scrobbles.Scrobble}
+	default:
+		resp, err := s.ScrobbleBatch(ctx, tracks)
+		if err != nil {
+			return nil, err
+		}
+
+		results = resp.Scrobbles.Scrobble
+	}
+
+	submissions := make([]models.Submission, 0, len(results))
+	for i, result := range results {
+		if i >= len(tracks) {
+			break
+		}
+
+		submissions = append(submissions, result.ToSubmission(tracks[i]))
+	}
+
+	return submissions, nil
+}
+
+// RecentTracks returns a page of the tracks the authenticated user scrobbled between
+// from and to, the latest first. Pages are numbered from 1.
+func (s *Service) RecentTracks(ctx context.Context, from, to time.Time, page int) (RecentTracksResponse, error) {
+	session, err := s.sessionCache.Read()
+	if err != nil {
+		return RecentTracksResponse{}, err
+	}
+
+	query := url.Values{}
+	query.Add("format", "json")
+	query.Add("method", "user.getRecentTracks")
+	query.Add("user", session.Session.Name)
+	query.Add("limit", strconv.Itoa(RecentTracksPageSize))
+	query.Add("page", strconv.Itoa(page))
+	query.Add("api_key", s.cfg.APIKey)
+
+	if !from.IsZero() {
+		query.Add("from", strconv.FormatInt(from.Unix(), 10))
+	}
+
+	if !to.IsZero() {
+		query.Add("to", strconv.FormatInt(to.Unix(), 10))
+	}
+
+	u, err := url.Parse(s.cfg.APIURL)
+	if err != nil {
+		return RecentTracksResponse{}, err
+	}
+
+	u.RawQuery = query.Encode()
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		u.String(),
+		nil,
+	)
+	if err != nil {
+		return RecentTracksResponse{}, err
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return RecentTracksResponse{}, err
+	}
+
+	defer response.Body.Close()
+
+	buff, err := io.ReadAll(response.Body)
+	if err != nil {
+		return RecentTracksResponse{}, err
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		var errResp ErrorResponse
+		if err := json.Unmarshal(buff, &errResp); err != nil {
+			return RecentTracksResponse{}, err
+		}
+
+		return RecentTracksResponse{}, errResp
+	}
+
+	var recentResponse RecentTracksResponse
+	if err = json.Unmarshal(buff, &recentResponse); err != nil {
+		return RecentTracksResponse{}, err
+	}
+
+	return recentResponse, nil
+}
+
+// Scrobbled pages through the recent tracks, and returns every track
+// scrobbled between from and to. The track playing now isn't included.
+func (s *Service) Scrobbled(ctx context.Context, from, to time.Time) ([]models.Track, error) {
+	tracks := make([]models.Track, 0)
+	for page := 1; ; page++ {
+		resp, err := s.RecentTracks(ctx, from, to, page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, recent := range resp.RecentTracks.Track {
+			if recent.Date.UTS == "" {
+				continue
+			}
+
+			uts, err := strconv.ParseInt(recent.Date.UTS, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			tracks = append(tracks, models.Track{
+				Artist:    recent.Artist.Text,
+				Name:      recent.Name,
+				Album:     recent.Album.Text,
+				Timestamp: time.Unix(uts, 0),
+			})
+		}
+
+		// A missing total ends the paging as well
+		totalPages, _ := strconv.Atoi(resp.RecentTracks.Attr.TotalPages)
+		if page >= totalPages {
+			return tracks, nil
+		}
+	}
+}
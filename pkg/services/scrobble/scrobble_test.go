@@ -0,0 +1,232 @@
+package scrobble
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/config"
+	"github.com/dusnm/minidlna-scrobble/pkg/constants"
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/auth"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobbler"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/sessioncache"
+)
+
+func TestScrobbleResults(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{
+			name: "single",
+			json: `{"scrobbles":{"scrobble":{"track":{"corrected":"0","#text":"Roygbiv"}},"@attr":{"accepted":1,"ignored":0}}}`,
+		},
+		{
+			name: "array",
+			json: `{"scrobbles":{"scrobble":[{"track":{"corrected":"0","#text":"Roygbiv"}}],"@attr":{"accepted":1,"ignored":0}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var resp BatchScrobbleResponse
+			if err := json.Unmarshal([]byte(test.json), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			results := resp.Scrobbles.Scrobble
+			if len(results) != 1 || results[0].Track.Text != "Roygbiv" {
+				t.Errorf("expected a single result, got %+v", results)
+			}
+		})
+	}
+}
+
+func TestScrobbleBatch(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 20, 0, 0, 0, time.UTC)
+	tracks := []models.Track{
+		{Artist: "Boards of Canada", Name: "Roygbiv", Album: "Music Has the Right to Children", Timestamp: start},
+		{Artist: "Aphex Twin", Name: "Xtal", Timestamp: start.Add(time.Minute * 5)},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := map[string]string{
+			"method":       "track.scrobble",
+			"sk":           "session",
+			"artist[0]":    "Boards of Canada",
+			"album[0]":     "Music Has the Right to Children",
+			"timestamp[0]": fmt.Sprint(start.Unix()),
+			"artist[1]":    "Aphex Twin",
+			"track[1]":     "Xtal",
+			"timestamp[1]": fmt.Sprint(start.Add(time.Minute * 5).Unix()),
+		}
+
+		for param, value := range expected {
+			if r.PostForm.Get(param) != value {
+				t.Errorf("expected %s=%s, got %s", param, value, r.PostForm.Get(param))
+			}
+		}
+
+		fmt.Fprint(w, `{"scrobbles":{"scrobble":[
+			{"track":{"corrected":"0","#text":"Roygbiv"},"ignoredMessage":{"code":"0","#text":""}},
+			{"track":{"corrected":"0","#text":"Xtal"},"ignoredMessage":{"code":"1","#text":"Artist was ignored"}}
+		],"@attr":{"accepted":1,"ignored":1}}}`)
+	}))
+	defer server.Close()
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	sessionCache, err := sessioncache.New(constants.BackendLastFM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var session auth.SessionResponse
+	session.Session.Name = "test"
+	session.Session.Key = "session"
+	if err = sessionCache.Save(session); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(config.Credentials{
+		Name:         constants.BackendLastFM,
+		APIKey:       "key",
+		SharedSecret: "secret",
+		APIURL:       server.URL + "/2.0/",
+	}, sessionCache)
+
+	resp, err := s.ScrobbleBatch(context.Background(), tracks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := resp.Scrobbles.Scrobble
+	if len(results) != 2 || results[0].Ignored() || !results[1].Ignored() {
+		t.Errorf("expected the results in the order of the tracks, got %+v", results)
+	}
+
+	if _, err = s.ScrobbleBatch(context.Background(), nil); err != ErrBatchSize {
+		t.Errorf("expected %v for an empty batch, got %v", ErrBatchSize, err)
+	}
+}
+
+// The pages of recent tracks served by the fake API, the last one has a single track
+var recentTracksPages = []string{
+	`{"recenttracks":{"track":[
+		{"artist":{"#text":"Aphex Twin"},"name":"Xtal","album":{"#text":""},"@attr":{"nowplaying":"true"}},
+		{"artist":{"#text":"Boards of Canada"},"name":"Roygbiv","album":{"#text":"Music Has the Right to Children"},
+			"date":{"uts":"1740860401","#text":"01 Mar 2025, 20:20"}}
+	],"@attr":{"user":"test","page":"1","perPage":"200","totalPages":"2","total":"2"}}}`,
+	`{"recenttracks":{"track":
+		{"artist":{"#text":"Boards of Canada"},"name":"Aquarius","album":{"#text":"Music Has the Right to Children"},
+			"date":{"uts":"1740860101","#text":"01 Mar 2025, 20:15"}}
+	,"@attr":{"user":"test","page":"2","perPage":"200","totalPages":"2","total":"2"}}}`,
+}
+
+func TestScrobbled(t *testing.T) {
+	from := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		expected := map[string]string{
+			"method":  "user.getRecentTracks",
+			"user":    "test",
+			"api_key": "key",
+			"from":    fmt.Sprint(from.Unix()),
+			"to":      fmt.Sprint(to.Unix()),
+		}
+
+		for param, value := range expected {
+			if query.Get(param) != value {
+				t.Errorf("expected %s=%s, got %s", param, value, query.Get(param))
+			}
+		}
+
+		var page int
+		if _, err := fmt.Sscan(query.Get("page"), &page); err != nil || page < 1 || page > len(recentTracksPages) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":6,"message":"Invalid page"}`)
+
+			return
+		}
+
+		fmt.Fprint(w, recentTracksPages[page-1])
+	}))
+	defer server.Close()
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	sessionCache, err := sessioncache.New(constants.BackendLastFM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var session auth.SessionResponse
+	session.Session.Name = "test"
+	session.Session.Key = "session"
+	if err = sessionCache.Save(session); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(config.Credentials{Name: constants.BackendLastFM, APIKey: "key", APIURL: server.URL + "/2.0/"}, sessionCache)
+	tracks, err := s.Scrobbled(context.Background(), from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []models.Track{
+		{
+			Artist:    "Boards of Canada",
+			Name:      "Roygbiv",
+			Album:     "Music Has the Right to Children",
+			Timestamp: time.Unix(1740860401, 0),
+		},
+		{
+			Artist:    "Boards of Canada",
+			Name:      "Aquarius",
+			Album:     "Music Has the Right to Children",
+			Timestamp: time.Unix(1740860101, 0),
+		},
+	}
+
+	if len(tracks) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, tracks)
+	}
+
+	for i := range expected {
+		if tracks[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], tracks[i])
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		code uint
+		kind scrobbler.ErrorKind
+	}{
+		{code: CodeInvalidParameters, kind: scrobbler.ErrorInvalidTrack},
+		{code: CodeInvalidSessionKey, kind: scrobbler.ErrorAuth},
+		{code: CodeServiceOffline, kind: scrobbler.ErrorTemporary},
+		{code: CodeRateLimitExceeded, kind: scrobbler.ErrorTemporary},
+		// Invalid method, wrong for every track alike
+		{code: 3, kind: scrobbler.ErrorPermanent},
+	}
+
+	s := New(config.Credentials{Name: constants.BackendLastFM}, nil)
+	for _, tt := range tests {
+		err := fmt.Errorf("scrobbling: %w", ErrorResponse{Code: tt.code})
+		if kind := s.Classify(err); kind != tt.kind {
+			t.Errorf("expected %s for code %d, got %s", tt.kind, tt.code, kind)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package scrobbler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+)
+
+const (
+	// ErrorPermanent means the submission will never succeed and shouldn't be retried
+	ErrorPermanent ErrorKind = iota
+	// ErrorTemporary means the service is unreachable or overloaded, the submission should be retried later
+	ErrorTemporary
+	// ErrorAuth means the user has to re-authenticate before anything can be submitted
+	ErrorAuth
+	// ErrorCancelled means the submission was interrupted, e.g. because of a shutdown
+	ErrorCancelled
+	// ErrorInvalidTrack means a track was refused, which fails the whole
+	// submission, the other tracks can succeed when submitted without it
+	ErrorInvalidTrack
+)
+
+type (
+	ErrorKind int
+
+	// Scrobbler is implemented by every supported scrobbling service.
+	Scrobbler interface {
+		// Name uniquely identifies the service among the configured ones
+		Name() string
+		NowPlaying(ctx context.Context, track models.Track) (models.Submission, error)
+		// Submit records the tracks as played, the submissions
+		// are expected to be in the same order as the tracks.
+		Submit(ctx context.Context, tracks []models.Track) ([]models.Submission, error)
+		// Classify tells how an error returned by the service should be handled
+		Classify(err error) ErrorKind
+	}
+
+	// TargetError ties an error to the service that returned it.
+	TargetError struct {
+		Target string
+		Err    error
+	}
+
+	// FanOut notifies all of its targets of the track that's playing. Plays are
+	// submitted to each of its targets separately, so they can be retried independently.
+	FanOut struct {
+		targets []Scrobbler
+	}
+)
+
+func (e TargetError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Target, e.Err)
+}
+
+func (e TargetError) Unwrap() error {
+	return e.Err
+}
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorTemporary:
+		return "temporary"
+	case ErrorAuth:
+		return "auth"
+	case ErrorCancelled:
+		return "cancelled"
+	case ErrorInvalidTrack:
+		return "invalid track"
+	default:
+		return "permanent"
+	}
+}
+
+// ClassifyTransport handles the errors common to all HTTP based services.
+// The boolean is false if the error should be classified by the service itself.
+func ClassifyTransport(err error) (ErrorKind, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCancelled, true
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// Network errors, the service is unreachable
+		return ErrorTemporary, true
+	}
+
+	return ErrorPermanent, false
+}
+
+func NewFanOut(
+	targets ...Scrobbler,
+) *FanOut {
+	return &FanOut{
+		targets: targets,
+	}
+}
+
+func (f *FanOut) Targets() []Scrobbler {
+	return f.targets
+}
+
+// NowPlaying notifies every target. The track is reported
+// as ignored only if every target that responded ignored it.
+func (f *FanOut) NowPlaying(ctx context.Context, track models.Track) (models.Submission, error) {
+	submissions, err := fanOut(f, func(target Scrobbler) (models.Submission, error) {
+		return target.NowPlaying(ctx, track)
+	})
+	if len(submissions) == 0 {
+		return models.Submission{}, err
+	}
+
+	result := submissions[0]
+	for _, submission := range submissions[1:] {
+		result.Ignored = result.Ignored && submission.Ignored
+	}
+
+	if !result.Ignored {
+		result.IgnoredReason = ""
+	}
+
+	return result, err
+}
+
+// fanOut calls fn for every target concurrently and returns
+// the results of the successful ones, in the order of targets.
+func fanOut[T any](f *FanOut, fn func(target Scrobbler) (T, error)) ([]T, error) {
+	var (
+		wg      sync.WaitGroup
+		results = make([]T, len(f.targets))
+		errs    = make([]error, len(f.targets))
+	)
+
+	for i, target := range f.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result, err := fn(target)
+			if err != nil {
+				errs[i] = TargetError{Target: target.Name(), Err: err}
+				return
+			}
+
+			results[i] = result
+		}()
+	}
+
+	wg.Wait()
+
+	succeeded := make([]T, 0, len(f.targets))
+	for i, err := range errs {
+		if err != nil {
+			continue
+		}
+
+		succeeded = append(succeeded, results[i])
+	}
+
+	return succeeded, errors.Join(errs...)
+}
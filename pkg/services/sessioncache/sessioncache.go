@@ -2,48 +2,43 @@ package sessioncache
 
 import (
 	"encoding/json"
-	"errors"
 	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/dusnm/minidlna-scrobble/pkg/constants"
+	"github.com/dusnm/minidlna-scrobble/pkg/helpers"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/auth"
 )
 
 type (
 	Service struct {
-		dir string
+		dir  string
+		file string
 	}
 )
 
-func New() (*Service, error) {
-	cacheDir := "/var/cache"
-	v, set := os.LookupEnv(constants.XDGCacheDIR)
-	if set && v != "" && filepath.IsAbs(v) {
-		cacheDir = v
+// New returns the session cache of the named account.
+func New(account string) (*Service, error) {
+	cacheDir, err := helpers.CacheDir()
+	if err != nil {
+		return nil, err
 	}
 
-	cacheDir = filepath.Join(cacheDir, "minidlna-scrobbler")
-	_, err := os.Stat(cacheDir)
-	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return nil, err
-		}
-
-		err = os.Mkdir(cacheDir, 0o744)
-		if err != nil {
-			return nil, err
-		}
+	// The main last.fm account keeps the file name it always had
+	file := "session.json"
+	if account != constants.BackendLastFM {
+		file = "session-" + account + ".json"
 	}
 
 	return &Service{
-		dir: cacheDir,
+		dir:  cacheDir,
+		file: file,
 	}, nil
 }
 
 func (s *Service) Save(data auth.SessionResponse) error {
-	fPath := filepath.Join(s.dir, "session.json")
+	fPath := filepath.Join(s.dir, s.file)
 	f, err := os.OpenFile(fPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
 	if err != nil {
 		return err
@@ -62,7 +57,7 @@ func (s *Service) Save(data auth.SessionResponse) error {
 }
 
 func (s *Service) Read() (auth.SessionResponse, error) {
-	fPath := filepath.Join(s.dir, "session.json")
+	fPath := filepath.Join(s.dir, s.file)
 	f, err := os.OpenFile(fPath, os.O_RDONLY, 0o644)
 	if err != nil {
 		return auth.SessionResponse{}, err
@@ -0,0 +1,66 @@
+package watcher
+
+import (
+	"context"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/job"
+)
+
+// startPending holds on to the play until the next track starts
+// or the idle timeout elapses, whichever comes first.
+func (s *Service) startPending(ctx context.Context, c *client, playID int64, md models.Track) {
+	if _, ok := s.delay(ctx, playID, md); !ok {
+		return
+	}
+
+	c.pending = &md
+	c.pendingID = playID
+	s.startTimer(ctx, c, timerIdle, time.Duration(s.cfg.Rules.IdleTimeout))
+}
+
+// pendingStart returns when the pending play started, or the zero time.
+func (c *client) pendingStart() time.Time {
+	if c.pending == nil {
+		return time.Time{}
+	}
+
+	return c.pending.Timestamp
+}
+
+// finishPending scrobbles the pending play if it lasted long enough. A play
+// can't have lasted longer than the idle timeout, the renderer was idle otherwise.
+func (s *Service) finishPending(ctx context.Context, c *client, elapsed time.Duration) {
+	if c.pending == nil {
+		return
+	}
+
+	md := *c.pending
+	c.pending = nil
+	c.stopTimer(timerIdle)
+
+	elapsed = min(elapsed, time.Duration(s.cfg.Rules.IdleTimeout))
+	if !s.rules.Eligible(md.Duration, elapsed) {
+		s.logger.
+			Info().
+			Str("artist", md.Artist).
+			Str("track", md.Name).
+			Dur("elapsed", elapsed).
+			Msg("track not played long enough to scrobble")
+
+		s.skip(ctx, c.pendingID, history.ReasonNotPlayed)
+
+		return
+	}
+
+	err := s.jobService.Add(job.Job{
+		Ctx:    ctx,
+		Track:  md,
+		PlayID: c.pendingID,
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msg("")
+	}
+}
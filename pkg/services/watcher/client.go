@@ -0,0 +1,121 @@
+package watcher
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/job"
+)
+
+const (
+	// How long boundary mode waits for the next track
+	timerIdle timerKind = iota
+	// When the track up next starts playing
+	timerPromote
+)
+
+type (
+	timerKind int
+
+	// client is the playback state of a single renderer, identified by the address
+	// it connects from. minidlna only logs the address at the debug level, serves
+	// without one share the state of the zero address.
+	client struct {
+		addr netip.Addr
+		jobs map[string]scheduledJob
+		// The play that was due by the time it was read from the log,
+		// it's queued unless a serve read along with it cut it short
+		overdue *job.Job
+		// In boundary mode, the play waiting for the next track to start
+		pending   *models.Track
+		pendingID int64
+		// When the client was last served anything, going by the log
+		lastServe time.Time
+		// The play session of the track that's playing,
+		// and the track requested ahead of it
+		session *session
+		upNext  *PlayEvent
+		// The generation of each timer, one that
+		// fires after it was replaced is ignored
+		timers map[timerKind]int
+	}
+
+	// scheduledJob is a job of the client that can still be cancelled.
+	scheduledJob struct {
+		cancel context.CancelCauseFunc
+		dueAt  time.Time
+	}
+
+	// expiry is sent when a timer of a client fires.
+	expiry struct {
+		addr       netip.Addr
+		kind       timerKind
+		generation int
+	}
+)
+
+// client returns the state of the client with the address, a new one if it wasn't seen before.
+func (s *Service) client(addr netip.Addr) *client {
+	c, ok := s.clients[addr]
+	if !ok {
+		c = &client{
+			addr:   addr,
+			jobs:   make(map[string]scheduledJob, 0),
+			timers: make(map[timerKind]int, 2),
+		}
+
+		s.clients[addr] = c
+	}
+
+	return c
+}
+
+// startTimer replaces the timer of the client, it fires after d.
+func (s *Service) startTimer(ctx context.Context, c *client, kind timerKind, d time.Duration) {
+	c.timers[kind]++
+	e := expiry{addr: c.addr, kind: kind, generation: c.timers[kind]}
+	fired := s.clock.After(d)
+
+	go func() {
+		select {
+		case <-fired:
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case s.expiries <- e:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// stopTimer makes the running timer of the client be ignored.
+func (c *client) stopTimer(kind timerKind) {
+	c.timers[kind]++
+}
+
+func (s *Service) handleExpiry(ctx context.Context, e expiry) {
+	c, ok := s.clients[e.addr]
+	if !ok || c.timers[e.kind] != e.generation {
+		return
+	}
+
+	switch e.kind {
+	case timerIdle:
+		// Nothing started in time, the renderer may have been stopped
+		// right after the last serve, which is all that's known to have played
+		s.finishPending(ctx, c, c.lastServe.Sub(c.pendingStart()))
+	case timerPromote:
+		// Serves that are waiting can be from before the
+		// track up next started, they're handled first
+		if len(s.events) > 0 {
+			s.startTimer(ctx, c, timerPromote, 0)
+			return
+		}
+
+		s.promoteUpNext(ctx, c)
+	}
+}
@@ -0,0 +1,87 @@
+package watcher
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/logparser"
+	"github.com/rs/zerolog"
+)
+
+type (
+	// PlayEvent is produced for every "Serving DetailID" line of the log.
+	PlayEvent struct {
+		// When the track was served, zero if the log has no timestamps
+		Timestamp time.Time
+		DetailID  int
+		Path      string
+		// Address of the client the track was served to, the zero
+		// address when minidlna doesn't log connections at the debug level
+		Client netip.Addr
+		// Where the client started reading the file, 0 for the start or
+		// when minidlna doesn't log HTTP requests at the debug level
+		RangeStart int64
+	}
+
+	// parser keeps the client and range of the request being logged, which come
+	// before the file is served and can be written in a different read of the log.
+	parser struct {
+		addr       netip.Addr
+		rangeStart int64
+	}
+)
+
+// parse turns the lines into play events, in the order they were logged.
+func (p *parser) parse(lines []string, logger zerolog.Logger) []PlayEvent {
+	events := make([]PlayEvent, 0, len(lines))
+
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		parsed, err := logparser.ParseLine(line)
+		if err != nil {
+			logger.Warn().Err(err).Msg("")
+			continue
+		}
+
+		if r, ok := parsed.Range(); ok {
+			p.rangeStart = r.Start
+			continue
+		}
+
+		// A new request, the range of the previous one doesn't apply
+		if conn, ok := parsed.HTTPConnection(); ok {
+			p.addr = conn.Addr.Addr().Unmap()
+			p.rangeStart = 0
+			continue
+		}
+
+		serving, ok := parsed.Serving()
+		if !ok {
+			logger.
+				Debug().
+				Stringer("kind", parsed.Kind).
+				Str("line", line).
+				Msg("not interested in this log line")
+
+			continue
+		}
+
+		events = append(events, PlayEvent{
+			Timestamp:  parsed.Timestamp,
+			DetailID:   serving.DetailID,
+			Path:       serving.Path,
+			Client:     p.addr,
+			RangeStart: p.rangeStart,
+		})
+
+		// The request is served, the next one is logged with its own client
+		p.addr = netip.Addr{}
+		p.rangeStart = 0
+	}
+
+	return events
+}
@@ -0,0 +1,156 @@
+package watcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+type (
+	// goldenEvent is a play event as stored in the golden files,
+	// the timestamp is in local time like in the log
+	goldenEvent struct {
+		Timestamp  string `json:"timestamp,omitempty"`
+		DetailID   int    `json:"detail_id"`
+		Path       string `json:"path"`
+		Client     string `json:"client,omitempty"`
+		RangeStart int64  `json:"range_start,omitempty"`
+	}
+)
+
+func TestParsePlays(t *testing.T) {
+	// A single write with several plays, e.g. after the renderer skipped through tracks
+	lines := []string{
+		"upnphttp.c:1923: info: Serving DetailID: 1529 [/srv/music/Boards of Canada/03 - The Color of the Fire.flac]",
+		"upnphttp.c:1180: warn: Unhandled header: Transfermode.dlna.org",
+		"",
+		"upnphttp.c:1923: info: Serving DetailID: 1531 [/srv/music/Boards of Canada/04 - Telephasic Workshop.flac]",
+		"upnphttp.c:1923: info: Serving DetailID: 1529 [/srv/music/Boards of Canada/03 - The Color of the Fire.flac]",
+	}
+
+	expected := []PlayEvent{
+		{DetailID: 1529, Path: "/srv/music/Boards of Canada/03 - The Color of the Fire.flac"},
+		{DetailID: 1531, Path: "/srv/music/Boards of Canada/04 - Telephasic Workshop.flac"},
+		{DetailID: 1529, Path: "/srv/music/Boards of Canada/03 - The Color of the Fire.flac"},
+	}
+
+	events := (&parser{}).parse(lines, zerolog.Nop())
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), events)
+	}
+
+	for i, event := range events {
+		if event.DetailID != expected[i].DetailID || event.Path != expected[i].Path {
+			t.Errorf("expected event %d to be %+v, got %+v", i, expected[i], event)
+		}
+	}
+}
+
+func TestParsePlaysGolden(t *testing.T) {
+	logs, err := filepath.Glob(filepath.Join("testdata", "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, log := range logs {
+		t.Run(filepath.Base(log), func(t *testing.T) {
+			events := (&parser{}).parse(readLines(t, log), zerolog.Nop())
+
+			actual := make([]goldenEvent, 0, len(events))
+			for _, event := range events {
+				e := goldenEvent{DetailID: event.DetailID, Path: event.Path, RangeStart: event.RangeStart}
+				if !event.Timestamp.IsZero() {
+					e.Timestamp = event.Timestamp.Format(time.DateTime)
+				}
+
+				if event.Client.IsValid() {
+					e.Client = event.Client.String()
+				}
+
+				actual = append(actual, e)
+			}
+
+			buff, err := json.MarshalIndent(actual, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := strings.TrimSuffix(log, ".log") + ".golden.json"
+			if *update {
+				if err = os.WriteFile(golden, append(buff, '\n'), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(expected) != string(buff)+"\n" {
+				t.Errorf("play events differ from %s, got:\n%s", golden, buff)
+			}
+		})
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return lines
+}
+
+func TestParsePlaysAcrossReads(t *testing.T) {
+	lines := readLines(t, filepath.Join("testdata", "ranges.log"))
+
+	p := &parser{}
+	events := append(p.parse(lines[:2], zerolog.Nop()), p.parse(lines[2:], zerolog.Nop())...)
+	expected := (&parser{}).parse(lines, zerolog.Nop())
+
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %d: expected %+v, got %+v", i, expected[i], events[i])
+		}
+	}
+
+	// A serve without a logged connection isn't attributed to the previous client
+	p = &parser{}
+	p.parse(lines[:4], zerolog.Nop())
+	events = p.parse([]string{
+		"[2025/03/02 19:10:00] upnphttp.c:1923: info: Serving DetailID: 2104 [/srv/music/Grouper/Ruins/04 - Holding.flac]",
+	}, zerolog.Nop())
+
+	if len(events) != 1 || events[0].Client.IsValid() {
+		t.Errorf("expected a single event without a client, got %+v", events)
+	}
+}
@@ -0,0 +1,169 @@
+package watcher
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// A track change, the served track started playing
+	verdictPlay verdict = iota
+	// The next track was requested ahead of time, it's up next
+	verdictPrefetch
+	// More of a track that was already requested
+	verdictContinuation
+	// The track that was up next is playing after all, and
+	// the served one was requested ahead of time in its place
+	verdictPromote
+)
+
+type (
+	// verdict is what a served track means for the one that's playing.
+	verdict int
+)
+
+func (v verdict) String() string {
+	switch v {
+	case verdictPrefetch:
+		return "prefetch"
+	case verdictContinuation:
+		return "continuation"
+	case verdictPromote:
+		return "promote"
+	default:
+		return "play"
+	}
+}
+
+// classify decides what the serve means, given the session of the track
+// that's playing and the track that's up next, either can be nil. Gapless
+// renderers request the next file shortly after the current one starts, so
+// not every serve is a track change. Serves within the window are prefetches.
+// Serves that continue the session are expected to be sorted out already.
+func classify(window time.Duration, current *session, upNext *PlayEvent, event PlayEvent) verdict {
+	if window <= 0 {
+		return verdictPlay
+	}
+
+	within := func(start PlayEvent) bool {
+		return event.Timestamp.Sub(start.Timestamp) <= window
+	}
+
+	if upNext != nil && event.DetailID == upNext.DetailID {
+		if event.RangeStart > 0 {
+			return verdictContinuation
+		}
+
+		// Requested again from the start, the renderer is playing it now
+		return verdictPlay
+	}
+
+	// Reading from the middle of a file that wasn't requested before is a seek
+	if event.RangeStart > 0 {
+		return verdictPlay
+	}
+
+	// What was taken to be prefetched was a track change, this serve is its prefetch
+	if upNext != nil && within(*upNext) {
+		return verdictPromote
+	}
+
+	if current != nil && within(current.event) {
+		return verdictPrefetch
+	}
+
+	return verdictPlay
+}
+
+// handleServe sorts out repeated serves and prefetches before handling the serve as a play.
+func (s *Service) handleServe(ctx context.Context, event PlayEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = s.clock.Now()
+	}
+
+	c := s.client(event.Client)
+	c.lastServe = event.Timestamp
+
+	// The track that's up next started before this one was served
+	if at, ok := c.promotionTime(); ok && !event.Timestamp.Before(at) {
+		s.promoteUpNext(ctx, c)
+	}
+
+	if c.session != nil && c.session.continues(event, time.Duration(s.cfg.Rules.IdleTimeout)) {
+		s.logger.
+			Debug().
+			Int("id", event.DetailID).
+			Int64("range_start", event.RangeStart).
+			Time("started_at", c.session.event.Timestamp).
+			Msg("continuing play session")
+
+		return
+	}
+
+	v := classify(time.Duration(s.cfg.Rules.PrefetchWindow), c.session, c.upNext, event)
+	s.logger.
+		Debug().
+		Int("id", event.DetailID).
+		Int64("range_start", event.RangeStart).
+		Stringer("verdict", v).
+		Msg("classified serve")
+
+	switch v {
+	case verdictContinuation:
+		return
+	case verdictPrefetch:
+		s.setUpNext(ctx, c, event)
+		return
+	case verdictPromote:
+		next := *c.upNext
+		s.handlePlay(ctx, c, next)
+		s.setUpNext(ctx, c, event)
+		return
+	}
+
+	c.upNext = nil
+	c.stopTimer(timerPromote)
+	s.handlePlay(ctx, c, event)
+}
+
+// setUpNext queues the track to start playing once the current one ends.
+func (s *Service) setUpNext(ctx context.Context, c *client, event PlayEvent) {
+	s.logger.
+		Info().
+		Int("id", event.DetailID).
+		Str("path", event.Path).
+		Msg("track prefetched, up next")
+
+	c.upNext = &event
+	c.stopTimer(timerPromote)
+
+	if at, ok := c.promotionTime(); ok {
+		s.startTimer(ctx, c, timerPromote, at.Sub(s.clock.Now()))
+	}
+}
+
+// promotionTime returns when the current track ends and the one up next
+// starts. The boolean is false if there's nothing up next, or it's unknown
+// when the current track ends.
+func (c *client) promotionTime() (time.Time, bool) {
+	if c.upNext == nil || c.session == nil || c.session.duration <= 0 {
+		return time.Time{}, false
+	}
+
+	return c.session.event.Timestamp.Add(c.session.duration), true
+}
+
+// promoteUpNext starts playing the track that's up next, from when the current one ended.
+func (s *Service) promoteUpNext(ctx context.Context, c *client) {
+	at, ok := c.promotionTime()
+	if !ok {
+		return
+	}
+
+	next := *c.upNext
+	next.Timestamp = at
+	c.upNext = nil
+	c.stopTimer(timerPromote)
+
+	s.handlePlay(ctx, c, next)
+}
@@ -0,0 +1,106 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	start := time.Date(2025, time.March, 2, 19, 0, 0, 0, time.Local)
+	serve := func(id int, after time.Duration, rangeStart int64) PlayEvent {
+		return PlayEvent{Timestamp: start.Add(after), DetailID: id, RangeStart: rangeStart}
+	}
+
+	current := &session{event: serve(1, 0, 0), duration: time.Minute * 3}
+	upNext := serve(2, time.Second*4, 0)
+
+	tests := []struct {
+		name     string
+		window   time.Duration
+		current  *session
+		upNext   *PlayEvent
+		event    PlayEvent
+		expected verdict
+	}{
+		{
+			name:     "disabled",
+			current:  current,
+			event:    serve(2, time.Second*4, 0),
+			expected: verdictPlay,
+		},
+		{
+			name:     "nothing playing",
+			window:   time.Second * 10,
+			event:    serve(1, 0, 0),
+			expected: verdictPlay,
+		},
+		{
+			name:     "prefetch",
+			window:   time.Second * 10,
+			current:  current,
+			event:    serve(2, time.Second*4, 0),
+			expected: verdictPrefetch,
+		},
+		{
+			name:     "track change",
+			window:   time.Second * 10,
+			current:  current,
+			event:    serve(2, time.Second*11, 0),
+			expected: verdictPlay,
+		},
+		{
+			name:     "played again",
+			window:   time.Second * 10,
+			current:  current,
+			event:    serve(1, time.Minute*3, 0),
+			expected: verdictPlay,
+		},
+		{
+			name:     "rest of the track up next",
+			window:   time.Second * 10,
+			current:  current,
+			upNext:   &upNext,
+			event:    serve(2, time.Minute, 4194304),
+			expected: verdictContinuation,
+		},
+		{
+			name:     "track up next requested again",
+			window:   time.Second * 10,
+			current:  current,
+			upNext:   &upNext,
+			event:    serve(2, time.Minute, 0),
+			expected: verdictPlay,
+		},
+		{
+			name:     "skipped to the track up next",
+			window:   time.Second * 10,
+			current:  current,
+			upNext:   &upNext,
+			event:    serve(3, time.Second*8, 0),
+			expected: verdictPromote,
+		},
+		{
+			name:     "seek into another track",
+			window:   time.Second * 10,
+			current:  current,
+			event:    serve(3, time.Second*2, 1024),
+			expected: verdictPlay,
+		},
+		{
+			name:     "another track after the window",
+			window:   time.Second * 10,
+			current:  current,
+			upNext:   &upNext,
+			event:    serve(3, time.Minute, 0),
+			expected: verdictPlay,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if v := classify(test.window, test.current, test.upNext, test.event); v != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package watcher
+
+import "time"
+
+type (
+	// session is a single playback of a track. Clients make several requests
+	// for one playback, e.g. to probe the file, or to resume after seeking,
+	// each of which is logged as a serve.
+	session struct {
+		// The serve that started the playback
+		event    PlayEvent
+		duration time.Duration
+	}
+)
+
+// continues reports whether the serve belongs to the session, rather than
+// starting another play of the track. A serve of the same track from the
+// start does if the track didn't end yet, one of the rest of the file always does.
+// Tracks without a known duration are assumed to end after the timeout.
+func (p *session) continues(event PlayEvent, timeout time.Duration) bool {
+	if event.DetailID != p.event.DetailID {
+		return false
+	}
+
+	if event.RangeStart > 0 {
+		return true
+	}
+
+	length := p.duration
+	if length <= 0 {
+		length = timeout
+	}
+
+	return event.Timestamp.Before(p.event.Timestamp.Add(length))
+}
@@ -0,0 +1,40 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionContinues(t *testing.T) {
+	start := time.Date(2025, time.March, 2, 19, 0, 0, 0, time.Local)
+	serve := func(id int, after time.Duration, rangeStart int64) PlayEvent {
+		return PlayEvent{Timestamp: start.Add(after), DetailID: id, RangeStart: rangeStart}
+	}
+
+	known := &session{event: serve(1, 0, 0), duration: time.Minute * 3}
+	unknown := &session{event: serve(1, 0, 0)}
+
+	tests := []struct {
+		name      string
+		session   *session
+		event     PlayEvent
+		continues bool
+	}{
+		{name: "probe", session: known, event: serve(1, 0, 0), continues: true},
+		{name: "requested again", session: known, event: serve(1, time.Second*2, 0), continues: true},
+		{name: "seek", session: known, event: serve(1, time.Minute, 4194304), continues: true},
+		{name: "resumed after the end", session: known, event: serve(1, time.Minute*20, 4194304), continues: true},
+		{name: "replay", session: known, event: serve(1, time.Minute*3, 0), continues: false},
+		{name: "another track", session: known, event: serve(2, time.Second, 0), continues: false},
+		{name: "unknown duration", session: unknown, event: serve(1, time.Minute*14, 0), continues: true},
+		{name: "unknown duration replay", session: unknown, event: serve(1, time.Minute*15, 0), continues: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if continues := test.session.continues(test.event, time.Minute*15); continues != test.continues {
+				t.Errorf("expected %v, got %v", test.continues, continues)
+			}
+		})
+	}
+}
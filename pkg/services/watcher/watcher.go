@@ -1,69 +1,128 @@
 package watcher
 
 import (
-	"bufio"
 	"context"
-	"os"
+	"errors"
+	"net/netip"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/dusnm/minidlna-scrobble/pkg/clock"
 	"github.com/dusnm/minidlna-scrobble/pkg/config"
 	"github.com/dusnm/minidlna-scrobble/pkg/constants"
 	"github.com/dusnm/minidlna-scrobble/pkg/helpers"
-	"github.com/dusnm/minidlna-scrobble/pkg/logparser"
 	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
 	"github.com/dusnm/minidlna-scrobble/pkg/repositories/metadata"
+	"github.com/dusnm/minidlna-scrobble/pkg/rules"
 	"github.com/dusnm/minidlna-scrobble/pkg/services/job"
-	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobble"
+	"github.com/dusnm/minidlna-scrobble/pkg/services/scrobbler"
+	"github.com/dusnm/minidlna-scrobble/pkg/tailer"
 	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog"
 )
 
+// How many reads of the log can wait for their play events to be handled
+const eventBufferSize = 64
+
 type (
 	Service struct {
-		cfg             *config.Config
-		logger          zerolog.Logger
-		metadata        *metadata.Repository
-		scrobbleService *scrobble.Service
-		jobService      *job.Service
-		jobs            map[string]context.CancelFunc
-		watcher         *fsnotify.Watcher
+		cfg        *config.Config
+		logger     zerolog.Logger
+		metadata   *metadata.Repository
+		history    *history.Repository
+		nowPlaying *scrobbler.FanOut
+		jobService *job.Service
+		watcher    *fsnotify.Watcher
+		tailer     *tailer.Tailer
+		parser     *parser
+		events     chan []PlayEvent
+		rules      rules.Rules
+		clock      clock.Clock
+		// Playback is tracked per client, so listeners
+		// on different renderers don't interfere
+		clients  map[netip.Addr]*client
+		expiries chan expiry
 	}
 )
 
 func New(
 	cfg *config.Config,
 	metadataRepo *metadata.Repository,
-	scrobbleService *scrobble.Service,
+	historyRepo *history.Repository,
+	nowPlaying *scrobbler.FanOut,
 	jobService *job.Service,
+	clk clock.Clock,
 	logger zerolog.Logger,
 ) (*Service, error) {
 	w, err := fsnotify.NewWatcher()
 	if err != nil {
-		return &Service{}, nil
+		return nil, err
+	}
+
+	cacheDir, err := helpers.CacheDir()
+	if err != nil {
+		return nil, errors.Join(err, w.Close())
+	}
+
+	t, err := tailer.New(cfg.LogFile, filepath.Join(cacheDir, "offset.json"))
+	if err != nil {
+		return nil, errors.Join(err, w.Close())
 	}
 
 	return &Service{
-		cfg:             cfg,
-		logger:          logger,
-		metadata:        metadataRepo,
-		scrobbleService: scrobbleService,
-		jobService:      jobService,
-		jobs:            make(map[string]context.CancelFunc, 0),
-		watcher:         w,
+		cfg:        cfg,
+		logger:     logger,
+		metadata:   metadataRepo,
+		history:    historyRepo,
+		nowPlaying: nowPlaying,
+		jobService: jobService,
+		watcher:    w,
+		tailer:     t,
+		parser:     &parser{},
+		events:     make(chan []PlayEvent, eventBufferSize),
+		rules:      rules.New(cfg.Rules),
+		clock:      clk,
+		clients:    make(map[netip.Addr]*client, 0),
+		expiries:   make(chan expiry),
 	}, nil
 }
 
 func (s *Service) Close() error {
 	s.logger.Info().Msg("closing")
 
-	return s.watcher.Close()
+	return errors.Join(s.watcher.Close(), s.tailer.Close())
 }
 
 func (s *Service) Watch(ctx context.Context) error {
+	// Plays are handled separately, so slow requests
+	// to the scrobbling services don't hold up reading
 	go func() {
+		for {
+			select {
+			case events := <-s.events:
+				for _, event := range events {
+					s.handleServe(ctx, event)
+				}
+			case e := <-s.expiries:
+				s.handleExpiry(ctx, e)
+			case <-ctx.Done():
+				return
+			}
+
+			// The serves read along with overdue plays were handled,
+			// those that weren't cut short by them played long enough
+			for _, c := range s.clients {
+				s.queueOverdue(ctx, c)
+			}
+		}
+	}()
+
+	go func() {
+		// Catch up with anything written while the application wasn't running
+		s.readLines(ctx)
+
 		for {
 			select {
 			case event, ok := <-s.watcher.Events:
@@ -71,7 +130,9 @@ func (s *Service) Watch(ctx context.Context) error {
 					return
 				}
 
-				if event.Name != s.cfg.LogFile {
+				// Rotated siblings are included, the old file can
+				// still be written to after it was moved away
+				if !strings.HasPrefix(event.Name, s.cfg.LogFile) {
 					s.logger.
 						Debug().
 						Str("event", event.String()).
@@ -80,72 +141,18 @@ func (s *Service) Watch(ctx context.Context) error {
 					continue
 				}
 
-				if !event.Has(fsnotify.Write) {
+				// Renames, removals and creations of the log file
+				// are how rotation shows up, the tailer handles them
+				if event.Op == fsnotify.Chmod {
 					s.logger.
 						Debug().
 						Str("event", event.String()).
-						Msg("not a write event")
-
-					continue
-				}
-
-				line, err := s.lastLine()
-				if err != nil {
-					s.logger.Error().Err(err).Msg("")
-					continue
-				}
-
-				if !strings.Contains(line, constants.MagicLogValue) {
-					s.logger.
-						Debug().
-						Str("line", line).
-						Msg("not interested in this log line")
-
-					continue
-				}
-
-				// Cancel any previously enqueued jobs
-				// if they didn't complete by now, they don't count
-				s.cancelJobs()
-
-				parsed, err := logparser.ParseLine(line)
-				if err != nil {
-					s.logger.Error().Err(err).Msg("")
-					continue
-				}
-
-				id, err := strconv.Atoi(parsed.MessageID)
-				if err != nil {
-					s.logger.Error().Err(err).Msg("")
-					continue
-				}
-
-				md, err := s.metadata.GetByID(ctx, id)
-				if err != nil {
-					s.logger.Error().Err(err).Msg("")
-					continue
-				}
-
-				npResp, err := s.scrobbleService.SendNowPlaying(ctx, md)
-				if err != nil {
-					s.logger.Error().Err(err).Msg("")
-					continue
-				}
-
-				if npResp.NowPlaying.IgnoredMessage.Code != "0" {
-					s.logger.
-						Info().
-						Str("artist", npResp.NowPlaying.Artist.Text).
-						Str("track", npResp.NowPlaying.Track.Text).
-						Str("ignored_for", npResp.NowPlaying.IgnoredMessage.Text).
-						Msg("ignoring track")
+						Msg("not interested in this event")
 
 					continue
 				}
 
-				if err = s.enqueueScrobble(ctx, md); err != nil {
-					s.logger.Error().Err(err).Msg("")
-				}
+				s.readLines(ctx)
 			case err, ok := <-s.watcher.Errors:
 				if !ok {
 					return
@@ -163,71 +170,243 @@ func (s *Service) Watch(ctx context.Context) error {
 	return nil
 }
 
-func (s *Service) lastLine() (string, error) {
-	f, err := os.OpenFile(s.cfg.LogFile, os.O_RDONLY, 0o644)
+// readLines turns every line appended to the log since the
+// last read into play events, and queues them to be handled together.
+// A single write can contain any number of lines and plays, and
+// catching up after a restart any number of writes.
+func (s *Service) readLines(ctx context.Context) {
+	lines, err := s.tailer.ReadLines()
 	if err != nil {
-		return "", nil
+		s.logger.Error().Err(err).Msg("")
 	}
 
-	defer f.Close()
+	events := s.parser.parse(lines, s.logger)
+	if len(events) == 0 {
+		return
+	}
+
+	select {
+	case s.events <- events:
+	case <-ctx.Done():
+	}
+}
 
-	line := ""
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line = scanner.Text()
+func (s *Service) handlePlay(ctx context.Context, c *client, event PlayEvent) {
+	s.logger.
+		Debug().
+		Stringer("client", c.addr).
+		Int("id", event.DetailID).
+		Str("path", event.Path).
+		Time("served_at", event.Timestamp).
+		Msg("play event")
+
+	if s.cfg.Rules.Mode == constants.ModeBoundary {
+		// The previous track played until this one started
+		s.finishPending(ctx, c, event.Timestamp.Sub(c.pendingStart()))
+	} else {
+		// Cancel any previously enqueued jobs of the client
+		// if they weren't due when this one started, they don't count
+		s.cancelJobs(ctx, c, event.Timestamp)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return "", err
+	c.session = &session{event: event}
+
+	md, err := s.metadata.GetByID(ctx, event.DetailID)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("")
+		return
 	}
 
-	return line, nil
+	c.session.duration = md.Duration
+
+	// The play started when the track was served, which can be a
+	// while ago if the log was written while the application wasn't running
+	md.Timestamp = event.Timestamp
+	playID := s.record(ctx, event, md)
+
+	// Serves read from the log after the fact can be of
+	// tracks that ended by now, they aren't playing anymore
+	if s.playing(md) && !s.sendNowPlaying(ctx, playID, md) {
+		return
+	}
+
+	if s.cfg.Rules.Mode == constants.ModeBoundary {
+		s.startPending(ctx, c, playID, md)
+		return
+	}
+
+	if err = s.enqueueScrobble(ctx, c, playID, md); err != nil {
+		s.logger.Error().Err(err).Msg("")
+	}
 }
 
-func (s *Service) cancelJobs() {
-	for id, cancel := range s.jobs {
+// playing reports whether the track can still be playing. Tracks
+// without a known duration are assumed to end after the idle timeout.
+func (s *Service) playing(md models.Track) bool {
+	length := md.Duration
+	if length <= 0 {
+		length = time.Duration(s.cfg.Rules.IdleTimeout)
+	}
+
+	return s.clock.Now().Before(md.Timestamp.Add(length))
+}
+
+// sendNowPlaying notifies the targets that the track is playing,
+// it returns false if it was ignored and shouldn't be scrobbled.
+func (s *Service) sendNowPlaying(ctx context.Context, playID int64, md models.Track) bool {
+	// A failed now playing notification is not a reason to
+	// skip the scrobble, it will be retried from the queue
+	np, err := s.nowPlaying.NowPlaying(ctx, md)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("")
+	}
+
+	if np.Ignored {
 		s.logger.
-			Debug().
-			Str("id", id).
-			Msg("cancelling job")
+			Info().
+			Str("artist", np.Track.Artist).
+			Str("track", np.Track.Name).
+			Str("ignored_for", np.IgnoredReason).
+			Msg("ignoring track")
+
+		s.skip(ctx, playID, "ignored: "+np.IgnoredReason)
 
-		cancel()
+		return false
 	}
 
-	s.jobs = make(map[string]context.CancelFunc, 0)
+	return true
 }
 
-func (s *Service) enqueueScrobble(ctx context.Context, md models.Track) error {
-	ctx, cancel := context.WithCancel(ctx)
-	if md.Duration <= time.Second*30 {
-		// Not worth scrobbling
+// cancelJobs cancels the jobs of the client that weren't due at the time,
+// going by the log rather than the clock, so plays read from the log after
+// the fact are judged the same. Jobs that were due are left to be sent.
+func (s *Service) cancelJobs(ctx context.Context, c *client, at time.Time) {
+	if c.overdue != nil && at.Before(c.overdue.DueAt()) {
 		s.logger.
 			Info().
-			Str("artist", md.Artist).
-			Str("track", md.Name).
-			Msg("track too short to scrobble")
+			Str("artist", c.overdue.Track.Artist).
+			Str("track", c.overdue.Track.Name).
+			Msg("track not played long enough to scrobble")
 
-		cancel()
+		s.skip(ctx, c.overdue.PlayID, history.ReasonNotPlayed)
+		c.overdue = nil
+	}
+
+	s.queueOverdue(ctx, c)
+
+	for id, j := range c.jobs {
+		if !at.Before(j.dueAt) {
+			j.cancel(nil)
+			continue
+		}
+
+		s.logger.
+			Debug().
+			Str("id", id).
+			Msg("cancelling job")
+
+		j.cancel(job.ErrCancelled)
+	}
+
+	c.jobs = make(map[string]scheduledJob, 0)
+}
+
+func (s *Service) enqueueScrobble(ctx context.Context, c *client, playID int64, md models.Track) error {
+	delay, ok := s.delay(ctx, playID, md)
+	if !ok {
 		return nil
 	}
 
-	delay := md.Duration / 2
-	if delay >= time.Minute*4 {
-		delay = time.Minute * 4
+	j := job.Job{
+		Delay:  delay,
+		Track:  md,
+		PlayID: playID,
 	}
 
+	if !j.DueAt().After(s.clock.Now()) {
+		// Read from the log after the fact, whether it played
+		// long enough is up to the serves read along with it
+		c.overdue = &j
+		return nil
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
 	jobID, err := helpers.RandomID()
 	if err != nil {
-		cancel()
+		cancel(nil)
 		return err
 	}
 
-	s.jobs[jobID] = cancel
-	s.jobService.Add(job.Job{
-		Ctx:   ctx,
-		Delay: delay,
-		Track: md,
-	})
+	j.Ctx = ctx
+	if err = s.jobService.Add(j); err != nil {
+		cancel(nil)
+		return err
+	}
+
+	c.jobs[jobID] = scheduledJob{cancel: cancel, dueAt: j.DueAt()}
 
 	return nil
 }
+
+// queueOverdue queues the overdue play of the client to be sent right away.
+func (s *Service) queueOverdue(ctx context.Context, c *client) {
+	if c.overdue == nil {
+		return
+	}
+
+	j := *c.overdue
+	j.Ctx = ctx
+	c.overdue = nil
+
+	if err := s.jobService.Add(j); err != nil {
+		s.logger.Error().Err(err).Msg("")
+	}
+}
+
+// delay returns how long the track has to play to be scrobbled,
+// the boolean is false if it's not worth scrobbling at all.
+func (s *Service) delay(ctx context.Context, playID int64, md models.Track) (time.Duration, bool) {
+	delay, ok := s.rules.Delay(md.Duration)
+	if !ok {
+		msg, reason := "track too short to scrobble", "too short"
+		if md.Duration <= 0 {
+			msg, reason = "track duration unknown, not scrobbling", "unknown duration"
+		}
+
+		s.logger.
+			Info().
+			Str("artist", md.Artist).
+			Str("track", md.Name).
+			Msg(msg)
+
+		s.skip(ctx, playID, reason)
+	}
+
+	return delay, ok
+}
+
+// record adds the play to the history, and returns its ID.
+// The ID is 0 if it couldn't be recorded, which doesn't stop the scrobble.
+func (s *Service) record(ctx context.Context, event PlayEvent, md models.Track) int64 {
+	id, err := s.history.Add(ctx, history.Play{
+		DetailID: event.DetailID,
+		Path:     event.Path,
+		Track:    md,
+	})
+	if err != nil {
+		s.logger.Error().Err(err).Msg("")
+	}
+
+	return id
+}
+
+// skip records in the history why the play isn't scrobbled.
+func (s *Service) skip(ctx context.Context, playID int64, reason string) {
+	if playID == 0 {
+		return
+	}
+
+	if err := s.history.Skip(ctx, playID, reason); err != nil {
+		s.logger.Error().Err(err).Msg("")
+	}
+}
@@ -0,0 +1,222 @@
+package stats
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+)
+
+// The layout of the dates of Day
+const dateLayout = time.DateOnly
+
+type (
+	// Lookup returns the metadata of the track with the given DetailID.
+	Lookup func(ctx context.Context, id int) (models.Track, error)
+
+	// Count is how often and how long an artist, album or track was listened to.
+	// Fields that don't apply, like the name of an artist, are empty.
+	Count struct {
+		Artist string
+		Album  string
+		Name   string
+		Plays  int
+		Time   time.Duration
+	}
+
+	Day struct {
+		Date  string
+		Plays int
+	}
+
+	// Streak is a run of consecutive days with plays.
+	Streak struct {
+		Start string
+		End   string
+		Days  int
+	}
+
+	Report struct {
+		Plays         int
+		ListeningTime time.Duration
+		Artists       []Count
+		Albums        []Count
+		Tracks        []Count
+		// Plays on every day with any, oldest first
+		Days []Day
+		// Plays by weekday, starting with Sunday, and hour of the day
+		Hours         [7][24]int
+		LongestStreak Streak
+		// The streak that includes today or yesterday, if any
+		CurrentStreak Streak
+	}
+)
+
+// Compute reports on the plays, keeping the top artists, albums and tracks.
+// Skipped plays weren't listened to, so they're left out. The tracks are
+// described by the current minidlna metadata where it still exists, so
+// corrected tags are counted together, and by what was recorded otherwise.
+func Compute(
+	ctx context.Context,
+	plays []history.Play,
+	lookup Lookup,
+	top int,
+	now time.Time,
+) (Report, error) {
+	var (
+		report  Report
+		artists = make(map[Count]Count)
+		albums  = make(map[Count]Count)
+		tracks  = make(map[Count]Count)
+		days    = make(map[string]int)
+		details = make(map[int]models.Track)
+	)
+
+	for _, play := range plays {
+		if play.Skipped != "" {
+			continue
+		}
+
+		track, err := describe(ctx, play, lookup, details)
+		if err != nil {
+			return Report{}, err
+		}
+
+		report.Plays++
+		report.ListeningTime += track.Duration
+
+		add(artists, Count{Artist: track.Artist}, track.Duration)
+		if track.Album != "" {
+			add(albums, Count{Artist: track.Artist, Album: track.Album}, track.Duration)
+		}
+
+		add(tracks, Count{Artist: track.Artist, Name: track.Name}, track.Duration)
+
+		playedAt := play.Track.Timestamp.In(now.Location())
+		days[playedAt.Format(dateLayout)]++
+		report.Hours[playedAt.Weekday()][playedAt.Hour()]++
+	}
+
+	report.Artists = ranked(artists, top)
+	report.Albums = ranked(albums, top)
+	report.Tracks = ranked(tracks, top)
+
+	report.Days = make([]Day, 0, len(days))
+	for date, n := range days {
+		report.Days = append(report.Days, Day{Date: date, Plays: n})
+	}
+
+	slices.SortFunc(report.Days, func(a, b Day) int {
+		return cmp.Compare(a.Date, b.Date)
+	})
+
+	report.LongestStreak, report.CurrentStreak = streaks(report.Days, now)
+
+	return report, nil
+}
+
+// describe returns the metadata of the played track, looked up once per DetailID.
+func describe(
+	ctx context.Context,
+	play history.Play,
+	lookup Lookup,
+	details map[int]models.Track,
+) (models.Track, error) {
+	if play.DetailID == 0 {
+		return play.Track, nil
+	}
+
+	if track, ok := details[play.DetailID]; ok {
+		return track, nil
+	}
+
+	track, err := lookup(ctx, play.DetailID)
+	if err != nil {
+		// The file was removed from the library since
+		if !errors.Is(err, sql.ErrNoRows) {
+			return models.Track{}, err
+		}
+
+		track = play.Track
+	}
+
+	details[play.DetailID] = track
+
+	return track, nil
+}
+
+func add(counts map[Count]Count, key Count, d time.Duration) {
+	count := counts[key]
+	if count.Plays == 0 {
+		count = key
+	}
+
+	count.Plays++
+	count.Time += d
+	counts[key] = count
+}
+
+// ranked returns the top counts, the most played first. Ties go to
+// the longest listened to, and then to the alphabetical order.
+func ranked(counts map[Count]Count, top int) []Count {
+	ranking := make([]Count, 0, len(counts))
+	for _, count := range counts {
+		ranking = append(ranking, count)
+	}
+
+	slices.SortFunc(ranking, func(a, b Count) int {
+		return cmp.Or(
+			cmp.Compare(b.Plays, a.Plays),
+			cmp.Compare(b.Time, a.Time),
+			cmp.Compare(a.Artist, b.Artist),
+			cmp.Compare(a.Album, b.Album),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+
+	if top > 0 && len(ranking) > top {
+		ranking = ranking[:top]
+	}
+
+	return ranking
+}
+
+// streaks returns the longest streak, and the one still going on at the time.
+// The days must be ordered, oldest first.
+func streaks(days []Day, now time.Time) (Streak, Streak) {
+	var longest, current Streak
+	for _, day := range days {
+		if current.Days > 0 && day.Date == nextDate(current.End) {
+			current.End = day.Date
+			current.Days++
+		} else {
+			current = Streak{Start: day.Date, End: day.Date, Days: 1}
+		}
+
+		if current.Days > longest.Days {
+			longest = current
+		}
+	}
+
+	today := now.Format(dateLayout)
+	if current.End != today && nextDate(current.End) != today {
+		current = Streak{}
+	}
+
+	return longest, current
+}
+
+// nextDate returns the date of the day after the date.
+func nextDate(date string) string {
+	t, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return ""
+	}
+
+	return t.AddDate(0, 0, 1).Format(dateLayout)
+}
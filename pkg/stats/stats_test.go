@@ -0,0 +1,113 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/dusnm/minidlna-scrobble/pkg/models"
+	"github.com/dusnm/minidlna-scrobble/pkg/repositories/history"
+)
+
+func TestCompute(t *testing.T) {
+	details := map[int]models.Track{
+		1: {Artist: "Boards of Canada", Album: "Geogaddi", Name: "Alpha and Omega", Duration: time.Minute * 7},
+		2: {Artist: "Boards of Canada", Album: "Geogaddi", Name: "Sunshine Recorder", Duration: time.Minute * 6},
+		3: {Artist: "Aphex Twin", Album: "Drukqs", Name: "Avril 14th", Duration: time.Minute * 2},
+	}
+
+	lookup := func(ctx context.Context, id int) (models.Track, error) {
+		track, ok := details[id]
+		if !ok {
+			return models.Track{}, sql.ErrNoRows
+		}
+
+		return track, nil
+	}
+
+	// A Sunday evening
+	start := time.Date(2025, time.March, 2, 20, 0, 0, 0, time.Local)
+	play := func(id int, at time.Time, skipped string) history.Play {
+		return history.Play{
+			DetailID: id,
+			// Tags fixed since the play was recorded
+			Track:   models.Track{Artist: "boards of canada", Name: "old tags", Duration: time.Minute, Timestamp: at},
+			Skipped: skipped,
+		}
+	}
+
+	plays := []history.Play{
+		play(1, start, ""),
+		play(2, start.Add(time.Minute*7), ""),
+		play(1, start.Add(time.Minute*13), ""),
+		play(3, start.Add(time.Minute*20), "not played long enough"),
+		// The next day, and two days later
+		play(3, start.AddDate(0, 0, 1), ""),
+		play(3, start.AddDate(0, 0, 3), ""),
+		// Removed from the library
+		play(4, start.AddDate(0, 0, 4), ""),
+	}
+
+	report, err := Compute(context.Background(), plays, lookup, 2, start.AddDate(0, 0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Plays != 6 || report.ListeningTime != time.Minute*25 {
+		t.Errorf("expected 6 plays for 25m, got %d for %v", report.Plays, report.ListeningTime)
+	}
+
+	expectCounts(t, "artists", report.Artists, []Count{
+		{Artist: "Boards of Canada", Plays: 3, Time: time.Minute * 20},
+		{Artist: "Aphex Twin", Plays: 2, Time: time.Minute * 4},
+	})
+
+	expectCounts(t, "tracks", report.Tracks, []Count{
+		{Artist: "Boards of Canada", Name: "Alpha and Omega", Plays: 2, Time: time.Minute * 14},
+		{Artist: "Aphex Twin", Name: "Avril 14th", Plays: 2, Time: time.Minute * 4},
+	})
+
+	if n := report.Hours[time.Sunday][20]; n != 3 {
+		t.Errorf("expected 3 plays on Sunday at 20, got %d", n)
+	}
+
+	if len(report.Days) != 4 || report.Days[0] != (Day{Date: "2025-03-02", Plays: 3}) {
+		t.Errorf("unexpected days %v", report.Days)
+	}
+
+	expected := Streak{Start: "2025-03-02", End: "2025-03-03", Days: 2}
+	if report.LongestStreak != expected {
+		t.Errorf("expected the longest streak %v, got %v", expected, report.LongestStreak)
+	}
+
+	// The last play was yesterday
+	expected = Streak{Start: "2025-03-05", End: "2025-03-06", Days: 2}
+	if report.CurrentStreak != expected {
+		t.Errorf("expected the current streak %v, got %v", expected, report.CurrentStreak)
+	}
+}
+
+func TestStreakBroken(t *testing.T) {
+	days := []Day{{Date: "2025-03-01", Plays: 1}, {Date: "2025-03-02", Plays: 1}}
+	now := time.Date(2025, time.March, 4, 12, 0, 0, 0, time.Local)
+
+	longest, current := streaks(days, now)
+	if longest.Days != 2 || current.Days != 0 {
+		t.Errorf("expected a longest streak of 2 days and none current, got %v and %v", longest, current)
+	}
+}
+
+func expectCounts(t *testing.T, what string, actual, expected []Count) {
+	t.Helper()
+
+	if len(actual) != len(expected) {
+		t.Fatalf("%s: expected %v, got %v", what, expected, actual)
+	}
+
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("%s: expected %v, got %v", what, expected[i], actual[i])
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package tailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+type (
+	// State is what's needed to resume reading where the previous run stopped.
+	State struct {
+		Inode  uint64 `json:"inode"`
+		Offset int64  `json:"offset"`
+	}
+
+	// Tailer reads lines appended to a file since the last read.
+	Tailer struct {
+		path      string
+		statePath string
+		file      *os.File
+		inode     uint64
+		// Offset just past the last complete line that was read
+		offset int64
+		// Data after the last complete line, the rest
+		// of the line hasn't been written yet
+		partial []byte
+		buff    []byte
+	}
+)
+
+// New creates a tailer for the file at path, saving its state at statePath.
+// If there's no saved state for the file, reading starts from its current end.
+func New(path string, statePath string) (*Tailer, error) {
+	t := &Tailer{
+		path:      path,
+		statePath: statePath,
+		buff:      make([]byte, 32*1024),
+	}
+
+	state, err := t.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a saved state, there's no telling what in the file was already
+	// processed, so only what's appended from now on is considered new
+	if err = t.open(state, state == State{}); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *Tailer) Close() error {
+	if t.file == nil {
+		return nil
+	}
+
+	return t.file.Close()
+}
+
+// ReadLines returns every complete line appended since the last call,
+// without the line terminators, and persists the new offset.
+// If the file was rotated, the rest of the old file is read before the new one.
+func (t *Tailer) ReadLines() ([]string, error) {
+	if t.file == nil {
+		// The file didn't exist so far, everything in it is new
+		if err := t.open(State{}, false); err != nil {
+			return nil, err
+		}
+
+		if t.file == nil {
+			return nil, nil
+		}
+	}
+
+	lines, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only check for rotation after the current file
+	// was read to its end, so nothing written to it is lost
+	rotated, err := t.reopenIfRotated()
+	if err != nil {
+		return nil, err
+	}
+
+	if rotated {
+		more, err := t.read()
+		if err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, more...)
+	}
+
+	if err = t.saveState(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+func (t *Tailer) read() ([]string, error) {
+	lines := make([]string, 0)
+	for {
+		n, err := t.file.Read(t.buff)
+		if n > 0 {
+			lines = t.split(lines, t.buff[:n])
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return lines, nil
+			}
+
+			return lines, err
+		}
+	}
+}
+
+func (t *Tailer) split(lines []string, data []byte) []string {
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			t.partial = append(t.partial, data...)
+			return lines
+		}
+
+		line := data[:i]
+		if len(t.partial) > 0 {
+			line = append(t.partial, line...)
+			t.partial = t.partial[:0]
+		}
+
+		t.offset += int64(len(line)) + 1
+		lines = append(lines, string(bytes.TrimSuffix(line, []byte{'\r'})))
+		data = data[i+1:]
+	}
+
+	return lines
+}
+
+// reopenIfRotated detects the file being moved away and replaced by a new one,
+// or truncated in place, and starts reading the current file from the beginning.
+func (t *Tailer) reopenIfRotated() (bool, error) {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// Moved away and not recreated yet, keep
+			// the old file open until the new one appears
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if inodeOf(info) != t.inode {
+		// The old file is complete, and it has been read to its end
+		if err = t.file.Close(); err != nil {
+			return false, err
+		}
+
+		t.file = nil
+		if err = t.open(State{}, false); err != nil {
+			return false, err
+		}
+
+		return t.file != nil, nil
+	}
+
+	if info.Size() < t.offset+int64(len(t.partial)) {
+		// Truncated, e.g. logrotate with copytruncate
+		if _, err = t.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+
+		t.offset = 0
+		t.partial = t.partial[:0]
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// open opens the file and positions it at its end, or according to the state.
+// A missing file isn't an error, it'll be opened on the next read.
+func (t *Tailer) open(state State, fromEnd bool) error {
+	f, err := os.OpenFile(t.path, os.O_RDONLY, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	inode := inodeOf(info)
+	offset := int64(0)
+	switch {
+	case fromEnd:
+		offset = info.Size()
+	case state.Inode == inode && state.Offset <= info.Size():
+		offset = state.Offset
+	default:
+		// The file was replaced or truncated in the meantime,
+		// everything in it is new
+	}
+
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	t.file = f
+	t.inode = inode
+	t.offset = offset
+	t.partial = t.partial[:0]
+
+	return nil
+}
+
+func (t *Tailer) loadState() (State, error) {
+	buff, err := os.ReadFile(t.statePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return State{}, nil
+		}
+
+		return State{}, err
+	}
+
+	var state State
+	if err = json.Unmarshal(buff, &state); err != nil {
+		return State{}, err
+	}
+
+	return state, nil
+}
+
+func (t *Tailer) saveState() error {
+	buff, err := json.Marshal(State{
+		Inode:  t.inode,
+		Offset: t.offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write and rename, so a crash can't leave a corrupted state behind
+	tmp := t.statePath + ".tmp"
+	if err = os.WriteFile(tmp, buff, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, t.statePath)
+}
+
+func inodeOf(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Ino
+	}
+
+	return 0
+}
@@ -0,0 +1,209 @@
+package tailer
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+type fixture struct {
+	t         *testing.T
+	path      string
+	statePath string
+}
+
+func newFixture(t *testing.T) fixture {
+	dir := t.TempDir()
+
+	return fixture{
+		t:         t,
+		path:      filepath.Join(dir, "minidlna.log"),
+		statePath: filepath.Join(dir, "offset.json"),
+	}
+}
+
+func (f fixture) append(path string, data string) {
+	f.t.Helper()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	if _, err = file.WriteString(data); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f fixture) tailer() *Tailer {
+	f.t.Helper()
+
+	tailer, err := New(f.path, f.statePath)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	f.t.Cleanup(func() { tailer.Close() })
+
+	return tailer
+}
+
+func (f fixture) expect(tailer *Tailer, expected ...string) {
+	f.t.Helper()
+
+	lines, err := tailer.ReadLines()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	if len(expected) == 0 && len(lines) == 0 {
+		return
+	}
+
+	if !slices.Equal(lines, expected) {
+		f.t.Fatalf("expected lines %q, got %q", expected, lines)
+	}
+}
+
+func TestStartsAtEndWithoutState(t *testing.T) {
+	f := newFixture(t)
+	f.append(f.path, "old 1\nold 2\n")
+
+	tailer := f.tailer()
+	f.expect(tailer)
+
+	f.append(f.path, "new 1\nnew 2\n")
+	f.expect(tailer, "new 1", "new 2")
+}
+
+func TestPartialLines(t *testing.T) {
+	f := newFixture(t)
+	f.append(f.path, "")
+
+	tailer := f.tailer()
+	f.append(f.path, "complete\nparti")
+	f.expect(tailer, "complete")
+
+	f.append(f.path, "al\r\n")
+	f.expect(tailer, "partial")
+}
+
+func TestResumesFromSavedOffset(t *testing.T) {
+	f := newFixture(t)
+	f.append(f.path, "")
+
+	tailer := f.tailer()
+	f.append(f.path, "first\n")
+	f.expect(tailer, "first")
+	tailer.Close()
+
+	f.append(f.path, "written while stopped\n")
+	f.expect(f.tailer(), "written while stopped")
+}
+
+func TestResumesFromStartOfReplacedFile(t *testing.T) {
+	f := newFixture(t)
+	f.append(f.path, "")
+
+	tailer := f.tailer()
+	f.append(f.path, "first\n")
+	f.expect(tailer, "first")
+	tailer.Close()
+
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.append(f.path, "rotated while stopped\n")
+	f.expect(f.tailer(), "rotated while stopped")
+}
+
+// logrotate's default mode, the file is moved away and a new one is created
+func TestRotationCreate(t *testing.T) {
+	f := newFixture(t)
+	f.append(f.path, "")
+
+	tailer := f.tailer()
+	f.append(f.path, "before\n")
+	f.expect(tailer, "before")
+
+	rotated := f.path + ".1"
+	if err := os.Rename(f.path, rotated); err != nil {
+		t.Fatal(err)
+	}
+
+	// minidlna keeps writing to the old file until it reopens it
+	f.append(rotated, "after rename\n")
+	f.append(f.path, "new file\n")
+	f.expect(tailer, "after rename", "new file")
+
+	f.append(f.path, "appended\n")
+	f.expect(tailer, "appended")
+}
+
+// The file is moved away and the new one only appears later
+func TestRotationRenameBeforeCreate(t *testing.T) {
+	f := newFixture(t)
+	f.append(f.path, "")
+
+	tailer := f.tailer()
+	rotated := f.path + ".1"
+	if err := os.Rename(f.path, rotated); err != nil {
+		t.Fatal(err)
+	}
+
+	f.append(rotated, "after rename\n")
+	f.expect(tailer, "after rename")
+
+	f.append(f.path, "new file\n")
+	f.expect(tailer, "new file")
+}
+
+// The file is moved away and removed, e.g. compressed, before the new one appears
+func TestRotationRemove(t *testing.T) {
+	f := newFixture(t)
+	f.append(f.path, "")
+
+	tailer := f.tailer()
+	f.append(f.path, "before\n")
+	if err := os.Remove(f.path); err != nil {
+		t.Fatal(err)
+	}
+
+	f.expect(tailer, "before")
+
+	f.append(f.path, "new file\n")
+	f.expect(tailer, "new file")
+}
+
+// logrotate's copytruncate mode, the file is copied and truncated in place
+func TestRotationCopyTruncate(t *testing.T) {
+	f := newFixture(t)
+	f.append(f.path, "")
+
+	tailer := f.tailer()
+	f.append(f.path, "a fairly long line before the truncation\n")
+	f.expect(tailer, "a fairly long line before the truncation")
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(f.path+".1", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Truncate(f.path, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	f.append(f.path, "short\n")
+	f.expect(tailer, "short")
+
+	f.append(f.path, "appended\n")
+	f.expect(tailer, "appended")
+}